@@ -0,0 +1,92 @@
+// WRP animated GIF capture of page motion
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"log"
+	"time"
+)
+
+const (
+	defAnimFrames = 10
+	maxAnimFrames = 50
+	defAnimDelay  = 200
+	maxAnimDelay  = 2000
+	// capture time limit, the session is locked meanwhile
+	maxAnimTime = 20 * time.Second
+)
+
+// Palette shared by all frames, quantized from the first, middle and last
+// frame stacked together
+func sharedPalette(frames []image.Image, n int64, pal string) color.Palette {
+	if p, ok := fixedPalette(pal); ok {
+		return p
+	}
+	pick := []image.Image{frames[0], frames[len(frames)/2], frames[len(frames)-1]}
+	b := frames[0].Bounds()
+	all := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()*len(pick)))
+	for i, f := range pick {
+		draw.Draw(all, b.Sub(b.Min).Add(image.Pt(0, b.Dy()*i)), f, b.Min, draw.Src)
+	}
+	return quantPalette(all, n, "")
+}
+
+// Capture more frames after the first screenshot and encode them as
+// a looping animated GIF
+func (rq *wrpReq) captureAnim(pngCap []byte, capH int64, pageH int64, seq string) (*screenshot, error) {
+	first, err := png.Decode(bytes.NewReader(pngCap))
+	if err != nil {
+		log.Printf("%s Failed to decode PNG screenshot: %s\n", rq.r.RemoteAddr, err)
+		return nil, fmt.Errorf("unable to decode page PNG screenshot: %v", err)
+	}
+	st := time.Now()
+	frames := []image.Image{first}
+	for len(frames) < int(rq.animFrames) && time.Since(st) < maxAnimTime {
+		time.Sleep(time.Duration(rq.animDelay) * time.Millisecond)
+		var buf []byte
+		if err := rq.sess.run(chromedpCaptureScreenshot(&buf, capH)); err != nil {
+			return nil, err
+		}
+		f, err := png.Decode(bytes.NewReader(buf))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode page PNG screenshot: %v", err)
+		}
+		if f.Bounds() != first.Bounds() {
+			log.Printf("%s Page size changed during animation, stopping at %d frames\n", rq.r.RemoteAddr, len(frames))
+			break
+		}
+		frames = append(frames, f)
+	}
+	pal := sharedPalette(frames, rq.nColors, rq.palette)
+	anim := &gif.GIF{LoopCount: 0}
+	for _, f := range frames {
+		anim.Image = append(anim.Image, ditherImage(f, pal, rq.dither))
+		anim.Delay = append(anim.Delay, int(rq.animDelay/10))
+	}
+	var gifBuf bytes.Buffer
+	if err := gif.EncodeAll(&gifBuf, anim); err != nil {
+		log.Printf("%s Failed to encode animated GIF: %s\n", rq.r.RemoteAddr, err)
+		return nil, fmt.Errorf("unable to encode animated GIF: %v", err)
+	}
+	imgPath := fmt.Sprintf("/img/%s.gif", seq)
+	rq.sess.cache.addImg(imgPath, gifBuf)
+	b := first.Bounds()
+	sc := &screenshot{
+		imgPath: imgPath,
+		mapPath: fmt.Sprintf("/map/%s.map", seq),
+		size:    fmt.Sprintf("%.0f KB", float32(gifBuf.Len())/1024.0),
+		width:   b.Dx(),
+		height:  b.Dy(),
+		pageH:   pageH,
+		token:   seq,
+	}
+	log.Printf("%s Encoded animated GIF: %s, Size: %s, Frames: %d, Colors: %d, Res: %dx%d, Time: %vms\n",
+		rq.r.RemoteAddr, imgPath, sc.size, len(frames), rq.nColors, sc.width, sc.height, time.Since(st).Milliseconds())
+	return sc, nil
+}
@@ -0,0 +1,163 @@
+// WRP browser process pool
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// Browser process hosting session tabs
+type browserProc struct {
+	n        int
+	opts     []chromedp.ExecAllocatorOption
+	actx     context.Context
+	acncl    context.CancelFunc
+	bctx     context.Context
+	sessions int
+	healthy  bool
+}
+
+type browserPool struct {
+	sync.Mutex
+	procs []*browserProc
+}
+
+var browsers browserPool
+
+func (bp *browserPool) start(n int, opts []chromedp.ExecAllocatorOption) {
+	for i := 0; i < max(n, 1); i++ {
+		o := opts
+		if *userDataDir != "" {
+			dir := *userDataDir
+			if n > 1 {
+				dir = filepath.Join(dir, fmt.Sprintf("wrp%d", i))
+			}
+			o = append(o[:len(o):len(o)], chromedp.UserDataDir(dir))
+		}
+		p := &browserProc{n: i, opts: o}
+		bp.procs = append(bp.procs, p)
+		if err := bp.launch(p); err != nil {
+			log.Printf("Browser #%d: unable to start: %v", i, err)
+			go bp.relaunch(p)
+		}
+	}
+}
+
+func (bp *browserPool) launch(p *browserProc) error {
+	actx, acncl := chromedp.NewExecAllocator(context.Background(), p.opts...)
+	// first context starts the browser, sessions open tabs in it
+	bctx, _ := chromedp.NewContext(actx)
+	err := chromedp.Run(bctx)
+	bp.Lock()
+	p.actx, p.acncl, p.bctx = actx, acncl, bctx
+	p.healthy = err == nil
+	bp.Unlock()
+	if err != nil {
+		return err
+	}
+	log.Printf("Browser #%d: started", p.n)
+	go bp.watch(p, actx, bctx)
+	return nil
+}
+
+// Wait for the browser to go away and relaunch it unless shutting down
+func (bp *browserPool) watch(p *browserProc, actx, bctx context.Context) {
+	c := chromedp.FromContext(bctx)
+	if c == nil || c.Browser == nil {
+		return
+	}
+	select {
+	case <-c.Browser.LostConnection:
+	case <-actx.Done():
+	}
+	if actx.Err() != nil {
+		return
+	}
+	log.Printf("Browser #%d: lost connection to browser, relaunching", p.n)
+	bp.relaunch(p)
+}
+
+// Launch the browser again until it starts, then restore its sessions
+func (bp *browserPool) relaunch(p *browserProc) {
+	wait := time.Second
+	for {
+		bp.Lock()
+		p.healthy = false
+		p.acncl()
+		bp.Unlock()
+		err := bp.launch(p)
+		if err == nil {
+			break
+		}
+		log.Printf("Browser #%d: relaunch failed: %v, retrying in %v", p.n, err, wait)
+		time.Sleep(wait)
+		wait = min(wait*2, time.Minute)
+	}
+	sessions.restore(p)
+}
+
+// Browser context to open new tabs in
+func (bp *browserPool) browserCtx(p *browserProc) context.Context {
+	bp.Lock()
+	defer bp.Unlock()
+	return p.bctx
+}
+
+// Pick the least loaded browser for a new session, preferring healthy ones
+func (bp *browserPool) pick() *browserProc {
+	bp.Lock()
+	defer bp.Unlock()
+	var best *browserProc
+	for _, p := range bp.procs {
+		if best == nil || (p.healthy && !best.healthy) ||
+			(p.healthy == best.healthy && p.sessions < best.sessions) {
+			best = p
+		}
+	}
+	best.sessions++
+	return best
+}
+
+func (bp *browserPool) release(p *browserProc) {
+	bp.Lock()
+	defer bp.Unlock()
+	p.sessions--
+}
+
+// Periodically probe each browser and log its health
+func (bp *browserPool) monitor() {
+	for range time.Tick(time.Minute) {
+		for _, p := range bp.procs {
+			st := time.Now()
+			tctx, tcncl := context.WithTimeout(bp.browserCtx(p), 10*time.Second)
+			err := chromedp.Run(tctx, chromedp.ActionFunc(func(ctx context.Context) error {
+				_, _, _, _, _, err := browser.GetVersion().Do(ctx)
+				return err
+			}))
+			tcncl()
+			bp.Lock()
+			p.healthy = err == nil
+			if err != nil {
+				log.Printf("Browser #%d: unhealthy, %d sessions, error: %v", p.n, p.sessions, err)
+			} else {
+				log.Printf("Browser #%d: healthy, %d sessions, latency: %vms", p.n, p.sessions, time.Since(st).Milliseconds())
+			}
+			bp.Unlock()
+		}
+	}
+}
+
+func (bp *browserPool) closeAll() {
+	bp.Lock()
+	defer bp.Unlock()
+	for _, p := range bp.procs {
+		p.acncl()
+	}
+}
@@ -0,0 +1,51 @@
+// WRP clipboard bridge between the remote page and the client
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+// Selected text of the page or of the focused text field, whole field value
+// if nothing is selected in it
+const copyJS = `(function(){
+` + activeJS + `
+var s=window.getSelection().toString();
+if(s)return s;
+var e=wrpActive();
+if(e&&(e.tagName==='INPUT'||e.tagName==='TEXTAREA'))return e.value.substring(e.selectionStart,e.selectionEnd)||e.value;
+return '';
+})()`
+
+// Copy selection into rq.clip for the UI text area
+func (rq *wrpReq) copyText() chromedp.Action {
+	return chromedp.Tasks{
+		chromedp.Evaluate(copyJS, &rq.clip),
+		chromedp.ActionFunc(rq.logClip),
+	}
+}
+
+// Copy text of the element at x,y
+func (rq *wrpReq) copyAt(x, y float64) chromedp.Action {
+	return chromedp.Tasks{
+		chromedp.Evaluate(fmt.Sprintf(`(function(){var e=document.elementFromPoint(%f,%f);return e?(e.innerText||e.value||e.alt||'').toString():''})()`, x, y), &rq.clip),
+		chromedp.ActionFunc(rq.logClip),
+	}
+}
+
+func (rq *wrpReq) logClip(context.Context) error {
+	log.Printf("%s Copied %d characters\n", rq.r.RemoteAddr, len(rq.clip))
+	return nil
+}
+
+// Insert posted text into the focused element
+func (rq *wrpReq) pasteText() chromedp.Action {
+	t := strings.ReplaceAll(rq.paste, "\r\n", "\n")
+	log.Printf("%s Pasting %d characters\n", rq.r.RemoteAddr, len(t))
+	return input.InsertText(t)
+}
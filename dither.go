@@ -0,0 +1,136 @@
+// WRP dithering of images reduced to a palette
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Dithering modes for the d form field and -dither flag. Auto keeps the old
+// behavior: Floyd-Steinberg for 2 colors, none otherwise.
+var ditherModes = []string{"auto", "none", "fs", "atkinson", "bayer4", "bayer8"}
+
+func validDither(d string) bool {
+	for _, m := range ditherModes {
+		if d == m {
+			return true
+		}
+	}
+	return false
+}
+
+var bayer4 = [][]float64{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+var bayer8 = [][]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// Map image to palette p using dithering mode d
+func ditherImage(i image.Image, p color.Palette, d string) *image.Paletted {
+	b := i.Bounds()
+	o := image.NewPaletted(b, p)
+	if d == "auto" {
+		d = "none"
+		if len(p) == 2 {
+			d = "fs"
+		}
+	}
+	switch d {
+	case "fs":
+		diffuse(o, i, fsTaps, 4)
+	case "atkinson":
+		diffuse(o, i, atkinsonTaps, 3)
+	case "bayer4":
+		ordered(o, i, bayer4)
+	case "bayer8":
+		ordered(o, i, bayer8)
+	default:
+		remapImage(o, i, nil, 0)
+	}
+	return o
+}
+
+// Error diffusion neighbor, row and column offset and weight of the error
+type errTap struct {
+	dy, dx int
+	w      int32
+}
+
+// Floyd-Steinberg spreads all of the error to four neighbors
+var fsTaps = []errTap{{0, 1, 7}, {1, -1, 3}, {1, 0, 5}, {1, 1, 1}}
+
+// Atkinson spreads 3/4 of the error to six neighbors
+var atkinsonTaps = []errTap{{0, 1, 1}, {0, 2, 1}, {1, -1, 1}, {1, 0, 1}, {1, 1, 1}, {2, 0, 1}}
+
+// Error diffusion dithering with tap weights divided by 1<<shift, colors are
+// looked up in the palette LUT
+func diffuse(o *image.Paletted, i image.Image, taps []errTap, shift uint) {
+	src := rgbaImage(i)
+	l := lutFor(o.Palette)
+	b := i.Bounds()
+	w := b.Dx()
+	// error times 1<<shift for the current and next two rows
+	errs := [3][][3]int32{}
+	for r := range errs {
+		errs[r] = make([][3]int32, w+4)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		sp := src.Pix[src.PixOffset(b.Min.X, y):]
+		op := o.Pix[o.PixOffset(b.Min.X, y):]
+		for x := 0; x < w; x++ {
+			c := x + 1
+			e := &errs[0][c]
+			r := clamp8(int32(sp[x*4]) + e[0]>>shift)
+			g := clamp8(int32(sp[x*4+1]) + e[1]>>shift)
+			bl := clamp8(int32(sp[x*4+2]) + e[2]>>shift)
+			n := l.index(uint8(r), uint8(g), uint8(bl))
+			op[x] = n
+			pc := &l.rgb[n]
+			qr, qg, qb := r-pc[0], g-pc[1], bl-pc[2]
+			for _, t := range taps {
+				d := &errs[t.dy][c+t.dx]
+				d[0] += qr * t.w
+				d[1] += qg * t.w
+				d[2] += qb * t.w
+			}
+		}
+		errs[0], errs[1], errs[2] = errs[1], errs[2], errs[0]
+		clear(errs[2])
+	}
+}
+
+// Ordered dithering with threshold matrix m, offset scaled to palette spacing
+func ordered(o *image.Paletted, i image.Image, m [][]float64) {
+	spread := 255 / math.Max(math.Cbrt(float64(len(o.Palette)))-1, 1)
+	if grayOnly(o.Palette) {
+		spread = 255 / float64(len(o.Palette)-1)
+	}
+	remapImage(o, i, m, spread)
+}
+
+func grayOnly(p color.Palette) bool {
+	for _, c := range p {
+		r, g, b, _ := c.RGBA()
+		if r != g || g != b {
+			return false
+		}
+	}
+	return true
+}
+
+func clamp8(v int32) int32 {
+	return min(max(v, 0), 255)
+}
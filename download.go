@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -16,10 +17,7 @@ import (
 	"github.com/lithammer/shortuuid/v4"
 )
 
-var (
-	dlDir    string
-	dlNotify = make(chan struct{}, 1)
-)
+var dlDir string
 
 type dlEvent struct {
 	guid     string
@@ -27,9 +25,11 @@ type dlEvent struct {
 	done     chan struct{}
 }
 
-var dlTrack struct {
+// Per session download tracking
+type dlState struct {
 	sync.Mutex
-	ev *dlEvent
+	ev     *dlEvent
+	notify chan struct{}
 }
 
 type dlFile struct {
@@ -42,34 +42,35 @@ var dlCache struct {
 	files map[string]dlFile
 }
 
-func setupDownloads() {
-	if dlDir == "" {
-		var err error
-		dlDir, err = os.MkdirTemp("", "wrp-dl-")
-		if err != nil {
-			log.Fatalf("Failed to create download dir: %v", err)
-		}
-		dlCache.files = make(map[string]dlFile)
+func initDownloads() {
+	var err error
+	dlDir, err = os.MkdirTemp("", "wrp-dl-")
+	if err != nil {
+		log.Fatalf("Failed to create download dir: %v", err)
 	}
+	dlCache.files = make(map[string]dlFile)
+}
+
+func setupDownloads(dl *dlState, ctx context.Context) {
 	chromedp.ListenTarget(ctx, func(ev interface{}) {
 		switch e := ev.(type) {
 		case *browser.EventDownloadWillBegin:
 			log.Printf("Download started: %s (%s)", e.SuggestedFilename, e.GUID)
-			dlTrack.Lock()
-			dlTrack.ev = &dlEvent{
+			dl.Lock()
+			dl.ev = &dlEvent{
 				guid:     e.GUID,
 				filename: e.SuggestedFilename,
 				done:     make(chan struct{}),
 			}
-			dlTrack.Unlock()
+			dl.Unlock()
 			select {
-			case dlNotify <- struct{}{}:
+			case dl.notify <- struct{}{}:
 			default:
 			}
 		case *browser.EventDownloadProgress:
-			dlTrack.Lock()
-			ev := dlTrack.ev
-			dlTrack.Unlock()
+			dl.Lock()
+			ev := dl.ev
+			dl.Unlock()
 			if ev == nil || ev.guid != e.GUID {
 				return
 			}
@@ -89,25 +90,25 @@ func setupDownloads() {
 	}
 }
 
-func resetDownloadState() {
-	dlTrack.Lock()
-	dlTrack.ev = nil
-	dlTrack.Unlock()
+func (dl *dlState) reset() {
+	dl.Lock()
+	dl.ev = nil
+	dl.Unlock()
 	select {
-	case <-dlNotify:
+	case <-dl.notify:
 	default:
 	}
 }
 
-func waitForDownload() *dlFile {
+func (dl *dlState) wait() *dlFile {
 	select {
-	case <-dlNotify:
+	case <-dl.notify:
 	case <-time.After(500 * time.Millisecond):
 		return nil
 	}
-	dlTrack.Lock()
-	ev := dlTrack.ev
-	dlTrack.Unlock()
+	dl.Lock()
+	ev := dl.ev
+	dl.Unlock()
 	if ev == nil {
 		return nil
 	}
@@ -116,16 +117,16 @@ func waitForDownload() *dlFile {
 	case <-ev.done:
 	case <-time.After(60 * time.Second):
 		log.Printf("Download timed out: %s", ev.guid)
-		dlTrack.Lock()
-		dlTrack.ev = nil
-		dlTrack.Unlock()
+		dl.Lock()
+		dl.ev = nil
+		dl.Unlock()
 		return nil
 	}
 	fpath := filepath.Join(dlDir, ev.guid)
 	data, err := os.ReadFile(fpath)
-	dlTrack.Lock()
-	dlTrack.ev = nil
-	dlTrack.Unlock()
+	dl.Lock()
+	dl.ev = nil
+	dl.Unlock()
 	if err != nil {
 		log.Printf("Failed to read download %s: %v", fpath, err)
 		return nil
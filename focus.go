@@ -0,0 +1,76 @@
+// WRP editor for the focused text field
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Active element, descending into shadow roots and same origin frames
+const activeJS = `function wrpActive(){
+	var e=document.activeElement;
+	for(;;){
+		if(e&&e.shadowRoot&&e.shadowRoot.activeElement){e=e.shadowRoot.activeElement;continue}
+		try{if(e&&e.contentDocument&&e.contentDocument.activeElement){e=e.contentDocument.activeElement;continue}}catch(x){}
+		return e;
+	}
+}`
+
+const focusJS = `(function(){
+` + activeJS + `
+var e=wrpActive(),r={ok:false};
+if(!e||e===document.body)return r;
+var t=(e.type||'').toLowerCase();
+if(e.isContentEditable){r.value=e.innerText;r.multi=true}
+else if(e.tagName==='TEXTAREA'){r.value=e.value;r.multi=true}
+else if(e.tagName==='INPUT'&&['text','search','email','url','tel','password','number'].indexOf(t)>=0){r.pass=t==='password';r.value=r.pass?'':e.value}
+else return r;
+var l=(e.labels&&e.labels.length)?e.labels[0].innerText:'';
+r.label=(l||e.getAttribute('aria-label')||e.placeholder||e.title||e.name||'').toString().trim().replace(/\s+/g,' ').slice(0,40);
+r.ok=true;
+return r;
+})()`
+
+// Text field having focus in the tab
+type focusField struct {
+	OK    bool   `json:"ok"`
+	Label string `json:"label"`
+	Value string `json:"value"`
+	Multi bool   `json:"multi"`
+	Pass  bool   `json:"pass"`
+}
+
+func (rq *wrpReq) focused() focusField {
+	var f focusField
+	rq.sess.run(chromedp.Evaluate(focusJS, &f))
+	return f
+}
+
+// Replace value of the focused field with the edited one from the UI
+func (rq *wrpReq) setFocused() chromedp.Action {
+	v, _ := json.Marshal(strings.ReplaceAll(rq.focusVal, "\r\n", "\n"))
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var set bool
+		err := chromedp.Evaluate(fmt.Sprintf(`(function(v){
+%s
+%s
+var e=wrpActive();
+if(!e||e===document.body)return false;
+if(e.type==='password'&&v==='')return false;
+if(!e.isContentEditable)return wrpSet(e,v);
+if(e.innerText===v)return false;
+e.innerText=v;
+e.dispatchEvent(new Event('input',{bubbles:true}));
+return true;
+})(%s)`, activeJS, setValueJS, v), &set).Do(ctx)
+		if set {
+			log.Printf("%s Replaced focused field value\n", rq.r.RemoteAddr)
+		}
+		return err
+	})
+}
@@ -0,0 +1,240 @@
+// WRP legacy HTML forms mirroring remote form fields in ISMAP mode
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+const fieldSel = `input,textarea,select`
+
+// Find visible form fields, index is the position in querySelectorAll(fieldSel).
+// Password values stay in the browser.
+const fieldsJS = `(function(){
+var r=[];
+document.querySelectorAll('` + fieldSel + `').forEach(function(e,i){
+	var t=(e.type||e.tagName).toLowerCase();
+	if(e.disabled||['hidden','submit','button','reset','image','file'].indexOf(t)>=0)return;
+	var b=e.getBoundingClientRect();
+	if(b.width<1||b.height<1||b.bottom<0||b.right<0||b.top>innerHeight||b.left>innerWidth)return;
+	var l=(e.labels&&e.labels.length)?e.labels[0].innerText:'';
+	l=(l||e.getAttribute('aria-label')||e.placeholder||e.title||e.name||'').toString().trim().replace(/\s+/g,' ').slice(0,60);
+	var o=[];
+	if(e.options)for(var j=0;j<e.options.length;j++)o.push({v:e.options[j].value,t:e.options[j].text,s:e.options[j].selected});
+	r.push({n:i,type:t,name:e.name||'',label:l,value:t==='password'?'':(e.value||'').toString(),checked:!!e.checked,opts:o,
+		form:e.form?Array.prototype.indexOf.call(document.forms,e.form):-1,x:b.left,y:b.top,w:b.width,h:b.height});
+});
+return r;
+})()`
+
+// Set field value the way a user would, so page scripts see input and change events
+const setValueJS = `function wrpSet(e,v){
+	var t=(e.type||'').toLowerCase();
+	if(t==='checkbox'||t==='radio'){
+		if(e.checked===v)return false;
+		e.checked=v;
+	}else{
+		if(e.value===v)return false;
+		var d=Object.getOwnPropertyDescriptor(Object.getPrototypeOf(e),'value');
+		e.focus();
+		if(d&&d.set){d.set.call(e,v)}else{e.value=v}
+	}
+	e.dispatchEvent(new Event('input',{bubbles:true}));
+	e.dispatchEvent(new Event('change',{bubbles:true}));
+	return true;
+}`
+
+// Remote form field
+type formField struct {
+	N       int        `json:"n"`
+	Type    string     `json:"type"`
+	Name    string     `json:"name"`
+	Label   string     `json:"label"`
+	Value   string     `json:"value"`
+	Checked bool       `json:"checked"`
+	Opts    []fieldOpt `json:"opts"`
+	Form    int        `json:"form"`
+	X       float64    `json:"x"`
+	Y       float64    `json:"y"`
+	W       float64    `json:"w"`
+	H       float64    `json:"h"`
+}
+
+type fieldOpt struct {
+	Value    string `json:"v"`
+	Text     string `json:"t"`
+	Selected bool   `json:"s"`
+}
+
+// Legacy input mirroring the remote field
+func (f *formField) input() string {
+	n := strconv.Itoa(f.N)
+	v := html.EscapeString(f.Value)
+	switch f.Type {
+	case "checkbox":
+		return fmt.Sprintf("<INPUT TYPE=\"CHECKBOX\" NAME=\"f%s\" VALUE=\"1\"%s>", n, checkedAttr(f.Checked, "CHECKED"))
+	case "radio":
+		return fmt.Sprintf("<INPUT TYPE=\"RADIO\" NAME=\"r%d_%s\" VALUE=\"%s\"%s>",
+			f.Form, html.EscapeString(f.Name), n, checkedAttr(f.Checked, "CHECKED"))
+	case "textarea":
+		return fmt.Sprintf("<TEXTAREA NAME=\"f%s\" ROWS=\"%d\" COLS=\"%d\">%s</TEXTAREA>",
+			n, min(max(int(f.H)/16, 2), 10), min(max(int(f.W)/8, 10), 60), v)
+	case "select-one", "select-multiple":
+		var b strings.Builder
+		fmt.Fprintf(&b, "<SELECT NAME=\"f%s\">", n)
+		for _, o := range f.Opts {
+			fmt.Fprintf(&b, "<OPTION VALUE=\"%s\"%s>%s</OPTION>",
+				html.EscapeString(o.Value), checkedAttr(o.Selected, "SELECTED"), html.EscapeString(o.Text))
+		}
+		b.WriteString("</SELECT>")
+		return b.String()
+	case "password":
+		return fmt.Sprintf("<INPUT TYPE=\"PASSWORD\" NAME=\"f%s\" SIZE=\"%d\">", n, min(max(int(f.W)/8, 4), 40))
+	}
+	return fmt.Sprintf("<INPUT TYPE=\"TEXT\" NAME=\"f%s\" VALUE=\"%s\" SIZE=\"%d\">", n, v, min(max(int(f.W)/8, 4), 40))
+}
+
+func checkedAttr(b bool, attr string) string {
+	if b {
+		return " " + attr
+	}
+	return ""
+}
+
+// Build one legacy form per remote form, fields in page order
+func formsHTML(fields []formField, seq string) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].Form != fields[j].Form {
+			return fields[i].Form < fields[j].Form
+		}
+		if int(fields[i].Y) != int(fields[j].Y) {
+			return fields[i].Y < fields[j].Y
+		}
+		return fields[i].X < fields[j].X
+	})
+	var b strings.Builder
+	for i := 0; i < len(fields); {
+		form := fields[i].Form
+		var ns []string
+		fmt.Fprintf(&b, "<FORM ACTION=\"/form/%s\" METHOD=\"POST\"><TABLE BORDER=\"0\">\n", seq)
+		for ; i < len(fields) && fields[i].Form == form; i++ {
+			f := &fields[i]
+			ns = append(ns, strconv.Itoa(f.N))
+			fmt.Fprintf(&b, "<TR><TD>%s</TD><TD>%s</TD></TR>\n", html.EscapeString(f.Label), f.input())
+		}
+		fmt.Fprintf(&b, "</TABLE><INPUT TYPE=\"HIDDEN\" NAME=\"fl\" VALUE=\"%s\">"+
+			"<INPUT TYPE=\"HIDDEN\" NAME=\"ff\" VALUE=\"%d\">"+
+			"<INPUT TYPE=\"SUBMIT\" NAME=\"Fn\" VALUE=\"Fill\">", strings.Join(ns, ","), form)
+		if form >= 0 {
+			b.WriteString(" <INPUT TYPE=\"SUBMIT\" NAME=\"Fn\" VALUE=\"Submit\">")
+		}
+		b.WriteString("</FORM>\n")
+	}
+	return b.String()
+}
+
+// Copy values of the legacy form to the remote fields and optionally submit
+// the remote form
+func (rq *wrpReq) fillForm() error {
+	var fields []formField
+	if err := rq.sess.run(chromedp.Evaluate(fieldsJS, &fields)); err != nil {
+		return err
+	}
+	byN := make(map[int]formField)
+	for _, f := range fields {
+		byN[f.N] = f
+	}
+	vals := make(map[int]any)
+	for _, s := range strings.Split(rq.r.FormValue("fl"), ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			continue
+		}
+		f, ok := byN[n]
+		if !ok {
+			continue
+		}
+		switch f.Type {
+		case "checkbox":
+			vals[n] = rq.r.FormValue("f"+s) == "1"
+		case "radio":
+			vals[n] = rq.r.FormValue(fmt.Sprintf("r%d_%s", f.Form, f.Name)) == s
+		case "password":
+			// left empty, keep what the page has, eg. autofilled
+			if v := rq.r.FormValue("f" + s); v != "" {
+				vals[n] = v
+			}
+		default:
+			vals[n] = strings.ReplaceAll(rq.r.FormValue("f"+s), "\r\n", "\n")
+		}
+	}
+	form := -1
+	if rq.r.FormValue("Fn") == "Submit" {
+		form, _ = strconv.Atoi(rq.r.FormValue("ff"))
+	}
+	js, _ := json.Marshal(vals)
+	var changed int
+	err := rq.sess.runOnce(chromedp.Evaluate(fmt.Sprintf(`(function(v,f){
+%s
+var e=document.querySelectorAll('%s'),c=0;
+for(var k in v){if(e[k]&&wrpSet(e[k],v[k]))c++}
+var fm=document.forms[f];
+if(fm){if(fm.requestSubmit){fm.requestSubmit()}else{fm.submit()}}
+return c;
+})(%s,%d)`, setValueJS, fieldSel, js, form), &changed))
+	log.Printf("%s Filled %d of %d fields, submit form %d\n", rq.r.RemoteAddr, changed, len(vals), form)
+	if err != nil || form < 0 {
+		return err
+	}
+	return rq.sess.run(waitForRender())
+}
+
+// Handle /form/<token> posts from the legacy forms. In proxy mode the forms
+// post to /form/ on the proxied site, other paths there are proxied as usual.
+func formServer(w http.ResponseWriter, r *http.Request) {
+	rq, err := parseToken(pathToken(r.URL.Path))
+	if err != nil && isProxyRequest(r) && !isSelfRequest(r) {
+		pageServer(w, r)
+		return
+	}
+	log.Printf("%s Form Request for %s\n", r.RemoteAddr, r.URL.Path)
+	s, _ := sessions.get(w, r)
+	if err != nil {
+		log.Printf("%s Unable to use form %s: %v\n", r.RemoteAddr, r.URL.Path, err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	rq.r, rq.w, rq.sess = r, w, s
+	r.ParseForm()
+	if !s.lock() {
+		rq.printBusy()
+		return
+	}
+	defer s.unlock()
+	if err := rq.restorePage(); err != nil {
+		rq.printErr(err)
+		return
+	}
+	if err := rq.fillForm(); err != nil {
+		rq.printErr(err)
+		return
+	}
+	if rq.proxy {
+		var loc string
+		rq.sess.run(chromedp.Location(&loc))
+		http.Redirect(w, r, strings.Replace(loc, "https://", "http://", 1), http.StatusFound)
+		return
+	}
+	rq.captureScreenshot()
+}
@@ -3,7 +3,6 @@ module github.com/tenox7/wrp
 go 1.26
 
 require (
-	github.com/MaxHalford/halfgone v0.0.0-20171017091812-482157b86ccb
 	github.com/PuerkitoBio/goquery v1.12.0
 	github.com/breml/rootcerts v0.3.5
 	github.com/chromedp/cdproto v0.0.0-20260405000525-47a8ff65b46a
@@ -5,11 +5,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"hash/fnv"
 	"image"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
-	"io"
 	"log"
 	"math"
 	"net/http"
@@ -22,7 +22,6 @@ import (
 	"github.com/chromedp/cdproto/input"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
-	"github.com/lithammer/shortuuid/v4"
 	"github.com/tenox7/gip"
 )
 
@@ -30,14 +29,9 @@ type cachedImg struct {
 	buf bytes.Buffer
 }
 
-type cachedMap struct {
-	req wrpReq
-}
-
 type wrpCache struct {
 	sync.Mutex
 	imgs map[string]cachedImg
-	maps map[string]cachedMap
 }
 
 func (c *wrpCache) addImg(path string, buf bytes.Buffer) {
@@ -56,30 +50,13 @@ func (c *wrpCache) getImg(path string) (bytes.Buffer, bool) {
 	return e.buf, true
 }
 
-func (c *wrpCache) addMap(path string, req wrpReq) {
-	c.Lock()
-	defer c.Unlock()
-	c.maps[path] = cachedMap{req: req}
-}
-
-func (c *wrpCache) getMap(path string) (wrpReq, bool) {
-	c.Lock()
-	defer c.Unlock()
-	e, ok := c.maps[path]
-	if !ok {
-		return wrpReq{}, false
-	}
-	return e.req, true
-}
-
 func (c *wrpCache) clear() {
 	c.Lock()
 	defer c.Unlock()
 	c.imgs = make(map[string]cachedImg)
-	c.maps = make(map[string]cachedMap)
 }
 
-func chromedpStart() (context.CancelFunc, context.CancelFunc) {
+func chromedpStart() {
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Flag("headless", *headless),
 		chromedp.Flag("hide-scrollbars", false),
@@ -103,24 +80,31 @@ func chromedpStart() (context.CancelFunc, context.CancelFunc) {
 	} else {
 		log.Printf("No browser detected, falling back to chromedp default lookup")
 	}
-	if *userDataDir != "" {
-		opts = append(opts, chromedp.UserDataDir(*userDataDir))
-	}
-	actx, acncl = chromedp.NewExecAllocator(context.Background(), opts...)
-	ctx, cncl = chromedp.NewContext(actx)
-	return cncl, acncl
+	browsers.start(*numBrowsers, opts)
 }
 
 // Determine what action to take
 func (rq *wrpReq) action() chromedp.Action {
+	// Edited focused field goes first, alone unless there is other input
+	if rq.focusSet && !isTabButton(rq.buttons) {
+		rq.focusSet = false
+		set := rq.setFocused()
+		if rq.buttons == "Set" || (rq.mouseX == 0 && rq.buttons == "" && rq.keys == "" && rq.url == rq.sess.tab().url) {
+			return set
+		}
+		return chromedp.Tasks{set, rq.action()}
+	}
 	// Mouse Click
 	if rq.mouseX > 0 && rq.mouseY > 0 {
-		log.Printf("%s Mouse Click %d,%d\n", rq.r.RemoteAddr, rq.mouseX, rq.mouseY)
-		return chromedp.MouseClickXY(float64(rq.mouseX)/float64(rq.zoom), float64(rq.mouseY)/float64(rq.zoom))
+		log.Printf("%s Mouse %s %d,%d\n", rq.r.RemoteAddr, rq.clickMode, rq.mouseX, rq.mouseY)
+		return rq.mouseAction(float64(rq.mouseX)/rq.zoom, float64(rq.mouseY)/rq.zoom)
 	}
 	// Buttons
 	if len(rq.buttons) > 0 {
 		log.Printf("%s Button %v\n", rq.r.RemoteAddr, rq.buttons)
+		if rq.tabAction() {
+			return chromedp.ActionFunc(func(context.Context) error { return nil })
+		}
 		switch rq.buttons {
 		case "Bk":
 			return chromedp.NavigateBack()
@@ -144,14 +128,34 @@ func (rq *wrpReq) action() chromedp.Action {
 			return chromedp.KeyEvent("\u0308")
 		case "Dn":
 			return chromedp.KeyEvent("\u0307")
+		case "Cp":
+			return rq.copyText()
+		case "Ps":
+			return rq.pasteText()
+		case "<<":
+			return rq.scrollX(-1)
+		case ">>":
+			return rq.scrollX(1)
 		case "All": // Select all
 			return chromedp.KeyEvent("a", chromedp.KeyModifiers(input.ModifierCtrl))
+		case "Tab":
+			return keyChords("{Tab}")
+		case "Esc":
+			return keyChords("{Esc}")
+		case "Hm":
+			return keyChords("{Home}")
+		case "En":
+			return keyChords("{End}")
 		}
 	}
 	// Keys
 	if len(rq.keys) > 0 {
 		log.Printf("%s Sending Keys: %#v\n", rq.r.RemoteAddr, rq.keys)
-		return chromedp.KeyEvent(rq.keys)
+		return keyChords(rq.keys)
+	}
+	// Scroll only, applied before capture
+	if rq.gotoSet && rq.url == rq.sess.tab().url {
+		return chromedp.ActionFunc(func(context.Context) error { return nil })
 	}
 	// Navigate to URL
 	log.Printf("%s Processing Navigate Request for %s\n", rq.r.RemoteAddr, rq.url)
@@ -159,28 +163,14 @@ func (rq *wrpReq) action() chromedp.Action {
 }
 
 // Navigate to the desired URL, returns the downloaded file if one was triggered.
-func (rq *wrpReq) navigate() *dlFile {
-	resetDownloadState()
-	ctxErr(chromedp.Run(ctx, rq.action()), rq.w)
-	return waitForDownload()
-}
-
-// Handle context errors
-func ctxErr(err error, w io.Writer) {
-	// TODO: callers should have retry logic, perhaps create another function
-	// that takes ...chromedp.Action and retries with give up
-	if err == nil {
-		return
-	}
-	log.Printf("Context error: %s", err)
-	fmt.Fprintf(w, "Context error: %s<BR>\n", err)
-	if err.Error() != "context canceled" {
-		return
+// Downloads abort the navigation so they are checked before the error.
+func (rq *wrpReq) navigate() (*dlFile, error) {
+	rq.sess.dl.reset()
+	err := rq.sess.runOnce(rq.action())
+	if dl := rq.sess.dl.wait(); dl != nil {
+		return dl, nil
 	}
-	ctx, cncl = chromedp.NewContext(actx)
-	setupDownloads()
-	log.Printf("Created new context, try again")
-	fmt.Fprintln(w, "Created new context, try again")
+	return nil, err
 }
 
 func waitForRender() chromedp.ActionFunc {
@@ -239,14 +229,37 @@ func chromedpCaptureScreenshot(res *[]byte, h int64) chromedp.Action {
 	})
 }
 
-// Capture Screenshot using CDP
-func (rq *wrpReq) captureScreenshot() {
-	wrpCach.clear()
+// Captured and encoded screenshot
+type screenshot struct {
+	imgPath string
+	mapPath string
+	size    string
+	width   int
+	height  int
+	pageH   int64
+	tiles   []tile
+	imgMap  string
+	forms   string
+	focus   focusField
+	token   string
+}
+
+// Hash of screenshot pixels and the settings used to encode them
+func (rq *wrpReq) shotKey(pngCap []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(pngCap)
+	fmt.Fprintf(h, "%s %s %d %s %s %d %v %v %d %d", rq.wrpMode, rq.imgType, rq.nColors, rq.palette, rq.dither, rq.jQual, rq.zoom, rq.useMap, rq.width, rq.height)
+	return h.Sum64()
+}
+
+// Capture screenshot using CDP, encode it and add to session cache
+func (rq *wrpReq) capture() (sc *screenshot, err error) {
 	var h int64
 	var pngCap []byte
-	chromedp.Run(ctx,
+	rq.sess.run(
 		emulation.SetDeviceMetricsOverride(int64(float64(rq.width)/rq.zoom), 10, rq.zoom, false),
 		chromedp.Location(&rq.url),
+		chromedp.Evaluate(`Math.round(window.scrollY)`, &rq.scrollY),
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			_, _, _, _, _, s, err := page.GetLayoutMetrics().Do(ctx)
 			if err == nil {
@@ -255,21 +268,63 @@ func (rq *wrpReq) captureScreenshot() {
 			return nil
 		}),
 	)
+	rq.pageH = h
+	rq.sess.remember(rq)
+	seq := rq.token()
+	landed := *rq
 	if rq.proxy {
 		rq.url = strings.Replace(rq.url, "https://", "http://", 1)
 	}
 	log.Printf("%s Landed on: %s, Height: %v\n", rq.r.RemoteAddr, rq.url, h)
-	height := int64(float64(rq.height) / rq.zoom)
-	if rq.height == 0 && h > 0 {
-		height = h + 30
+	capH := rq.height
+	if rq.viewH() == 0 && h > 0 {
+		capH = 0
 	}
-	chromedp.Run(
-		ctx, emulation.SetDeviceMetricsOverride(int64(float64(rq.width)/rq.zoom), height, rq.zoom, false),
-		waitForRender(),
-	)
+	rq.sess.run(rq.metrics(), waitForRender())
 	// Capture screenshot...
-	ctxErr(chromedp.Run(ctx, chromedpCaptureScreenshot(&pngCap, rq.height)), rq.w)
-	seq := shortuuid.New()
+	if err := rq.sess.run(chromedpCaptureScreenshot(&pngCap, capH)); err != nil {
+		return nil, err
+	}
+	var links []pageLink
+	if rq.useMap && rq.plainClicks() {
+		rq.sess.run(chromedp.Evaluate(linksJS, &links))
+	}
+	var fields []formField
+	rq.sess.run(chromedp.Evaluate(fieldsJS, &fields))
+	var focus focusField
+	if !rq.proxy {
+		focus = rq.focused()
+	}
+	key := rq.shotKey(pngCap)
+	if rq.refresh > 0 && rq.sess.prevShot != nil && rq.sess.prevKey == key {
+		log.Printf("%s Screenshot unchanged, reusing %s\n", rq.r.RemoteAddr, rq.sess.prevShot.imgPath)
+		return rq.sess.prevShot, nil
+	}
+	rq.sess.cache.clear()
+	defer func() {
+		if err == nil {
+			rq.sess.prevKey, rq.sess.prevShot = key, sc
+		}
+	}()
+	if rq.wrpMode == "tiles" {
+		sc, err := landed.captureTiles(pngCap, h, links)
+		if err != nil {
+			return nil, err
+		}
+		sc.forms = formsHTML(fields, seq)
+		sc.focus = focus
+		return sc, nil
+	}
+	if rq.wrpMode == "anim" {
+		sc, err := rq.captureAnim(pngCap, capH, h, seq)
+		if err != nil {
+			return nil, err
+		}
+		sc.imgMap = rq.imgMap("wrpmap", links, sc.mapPath, 0, sc.height)
+		sc.forms = formsHTML(fields, seq)
+		sc.focus = focus
+		return sc, nil
+	}
 	var imgExt string
 	if rq.imgType == "gip" {
 		imgExt = "gif"
@@ -278,7 +333,6 @@ func (rq *wrpReq) captureScreenshot() {
 	}
 	imgPath := fmt.Sprintf("/img/%s.%s", seq, imgExt)
 	mapPath := fmt.Sprintf("/map/%s.map", seq)
-	wrpCach.addMap(mapPath, *rq)
 	var sSize string
 	var iW, iH int
 	switch rq.imgType {
@@ -286,25 +340,23 @@ func (rq *wrpReq) captureScreenshot() {
 		i, err := png.Decode(bytes.NewReader(pngCap))
 		if err != nil {
 			log.Printf("%s Failed to decode PNG screenshot: %s\n", rq.r.RemoteAddr, err)
-			fmt.Fprintf(rq.w, "<BR>Unable to decode page PNG screenshot:<BR>%s<BR>\n", err)
-			return
+			return nil, fmt.Errorf("unable to decode page PNG screenshot: %v", err)
 		}
 		st := time.Now()
 		var gipBuf bytes.Buffer
 		err = gip.Encode(&gipBuf, i, nil)
 		if err != nil {
 			log.Printf("%s Failed to encode GIP: %s\n", rq.r.RemoteAddr, err)
-			fmt.Fprintf(rq.w, "<BR>Unable to encode GIP:<BR>%s<BR>\n", err)
-			return
+			return nil, fmt.Errorf("unable to encode GIP: %v", err)
 		}
-		wrpCach.addImg(imgPath, gipBuf)
+		rq.sess.cache.addImg(imgPath, gipBuf)
 		sSize = fmt.Sprintf("%.0f KB", float32(len(gipBuf.Bytes()))/1024.0)
 		iW = i.Bounds().Max.X
 		iH = i.Bounds().Max.Y
 		log.Printf("%s Encoded GIP image: %s, Size: %s, Res: %dx%d, Time: %vms\n", rq.r.RemoteAddr, imgPath, sSize, iW, iH, time.Since(st).Milliseconds())
 	case "png":
 		pngBuf := bytes.NewBuffer(pngCap)
-		wrpCach.addImg(imgPath, *pngBuf)
+		rq.sess.cache.addImg(imgPath, *pngBuf)
 		cfg, _, _ := image.DecodeConfig(pngBuf)
 		sSize = fmt.Sprintf("%.0f KB", float32(len(pngBuf.Bytes()))/1024.0)
 		iW = cfg.Width
@@ -314,18 +366,16 @@ func (rq *wrpReq) captureScreenshot() {
 		i, err := png.Decode(bytes.NewReader(pngCap))
 		if err != nil {
 			log.Printf("%s Failed to decode PNG screenshot: %s\n", rq.r.RemoteAddr, err)
-			fmt.Fprintf(rq.w, "<BR>Unable to decode page PNG screenshot:<BR>%s<BR>\n", err)
-			return
+			return nil, fmt.Errorf("unable to decode page PNG screenshot: %v", err)
 		}
 		st := time.Now()
 		var gifBuf bytes.Buffer
-		err = gif.Encode(&gifBuf, gifPalette(i, rq.nColors), &gif.Options{})
+		err = gif.Encode(&gifBuf, gifPalette(i, rq.nColors, rq.palette, rq.dither), &gif.Options{})
 		if err != nil {
 			log.Printf("%s Failed to encode GIF: %s\n", rq.r.RemoteAddr, err)
-			fmt.Fprintf(rq.w, "<BR>Unable to encode GIF:<BR>%s<BR>\n", err)
-			return
+			return nil, fmt.Errorf("unable to encode GIF: %v", err)
 		}
-		wrpCach.addImg(imgPath, gifBuf)
+		rq.sess.cache.addImg(imgPath, gifBuf)
 		sSize = fmt.Sprintf("%.0f KB", float32(len(gifBuf.Bytes()))/1024.0)
 		iW = i.Bounds().Max.X
 		iH = i.Bounds().Max.Y
@@ -334,64 +384,175 @@ func (rq *wrpReq) captureScreenshot() {
 		i, err := png.Decode(bytes.NewReader(pngCap))
 		if err != nil {
 			log.Printf("%s Failed to decode PNG screenshot: %s\n", rq.r.RemoteAddr, err)
-			fmt.Fprintf(rq.w, "<BR>Unable to decode page PNG screenshot:<BR>%s<BR>\n", err)
-			return
+			return nil, fmt.Errorf("unable to decode page PNG screenshot: %v", err)
 		}
 		st := time.Now()
 		var jpgBuf bytes.Buffer
 		err = jpeg.Encode(&jpgBuf, i, &jpeg.Options{Quality: int(rq.jQual)})
 		if err != nil {
 			log.Printf("%s Failed to encode JPG: %s\n", rq.r.RemoteAddr, err)
-			fmt.Fprintf(rq.w, "<BR>Unable to encode JPG:<BR>%s<BR>\n", err)
-			return
+			return nil, fmt.Errorf("unable to encode JPG: %v", err)
 		}
-		wrpCach.addImg(imgPath, jpgBuf)
+		rq.sess.cache.addImg(imgPath, jpgBuf)
 		sSize = fmt.Sprintf("%.0f KB", float32(len(jpgBuf.Bytes()))/1024.0)
 		iW = i.Bounds().Max.X
 		iH = i.Bounds().Max.Y
 		log.Printf("%s Encoded JPG image: %s, Size: %s, Quality: %d, Res: %dx%d, Time: %vms\n", rq.r.RemoteAddr, imgPath, sSize, *defJpgQual, iW, iH, time.Since(st).Milliseconds())
 	}
-	if rq.proxy {
+	return &screenshot{
+		imgPath: imgPath,
+		mapPath: mapPath,
+		size:    sSize,
+		width:   iW,
+		height:  iH,
+		pageH:   h,
+		imgMap:  rq.imgMap("wrpmap", links, mapPath, 0, iH),
+		forms:   formsHTML(fields, seq),
+		focus:   focus,
+		token:   seq,
+	}, nil
+}
+
+// Capture screenshot and send ISMAP page to the client
+func (rq *wrpReq) captureScreenshot() {
+	rq.applyScroll()
+	sc, err := rq.capture()
+	if err != nil {
+		rq.printErr(err)
+		return
+	}
+	switch {
+	case rq.proxy && sc.tiles != nil:
+		rq.w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(rq.w, "<HTML><HEAD>%s<TITLE>%s</TITLE></HEAD><BODY BGCOLOR=\"%s\">%s%s</BODY></HTML>",
+			rq.baseTag(), rq.url, *bgColor, tilesTable(sc.tiles), sc.forms)
+	case rq.proxy:
 		rq.w.Header().Set("Content-Type", "text/html")
 		fmt.Fprintf(rq.w, "<HTML><HEAD>%s<TITLE>%s</TITLE></HEAD><BODY BGCOLOR=\"%s\">"+
-			"<A HREF=\"%s\"><IMG SRC=\"%s\" BORDER=\"0\" WIDTH=\"%d\" HEIGHT=\"%d\" ISMAP></A>"+
-			"</BODY></HTML>", rq.baseTag(), rq.url, *bgColor, mapPath, imgPath, iW, iH)
-	} else {
+			"%s<A HREF=\"%s\"><IMG SRC=\"%s\" BORDER=\"0\" WIDTH=\"%d\" HEIGHT=\"%d\"%s ISMAP></A>%s"+
+			"</BODY></HTML>", rq.baseTag(), rq.url, *bgColor, sc.imgMap, sc.mapPath, sc.imgPath, sc.width, sc.height, useMapAttr(sc.imgMap, "wrpmap"), sc.forms)
+	default:
+		var text string
+		if rq.dragX > 0 {
+			text = fmt.Sprintf("<P>Dragging from %d,%d, click where to drop.</P>\n", rq.dragX, rq.dragY)
+		}
+		var stripURL, stripMap, liveURL string
+		if sc.token != "" && rq.viewH() > 0 {
+			stripURL, stripMap = "/strip/"+sc.token+".gif", "/strip/"+sc.token+".map"
+			liveURL = "/live/" + sc.token
+		}
 		rq.printUI(uiParams{
+			text:       text,
 			bgColor:    *bgColor,
-			pageHeight: fmt.Sprintf("%d PX", h),
-			imgSize:    sSize,
-			imgURL:     imgPath,
-			mapURL:     mapPath,
-			imgWidth:   iW,
-			imgHeight:  iH,
+			pageHeight: fmt.Sprintf("%d PX", sc.pageH),
+			imgSize:    sc.size,
+			imgURL:     sc.imgPath,
+			mapURL:     sc.mapPath,
+			imgWidth:   sc.width,
+			imgHeight:  sc.height,
+			imgMap:     sc.imgMap,
+			forms:      sc.forms,
+			focus:      sc.focus,
+			stripURL:   stripURL,
+			stripMap:   stripMap,
+			liveURL:    liveURL,
+			tiles:      sc.tiles,
 		})
 	}
 	log.Printf("%s Done with capture for %s\n", rq.r.RemoteAddr, rq.url)
 }
 
+// Viewport of the capture, full page height for tiles and unlimited height
+func (rq *wrpReq) metrics() chromedp.Action {
+	height := rq.viewH()
+	if height == 0 && rq.pageH > 0 {
+		height = rq.pageH + 30
+	}
+	if height == 0 {
+		height = int64(float64(rq.height) / rq.zoom)
+	}
+	return emulation.SetDeviceMetricsOverride(int64(float64(rq.width)/rq.zoom), height, rq.zoom, false)
+}
+
+// Bring the tab back to the viewport, page and scroll position the map or
+// image was captured at, eg. when clicking an older screenshot, from another
+// window or after restart
+func (rq *wrpReq) restorePage() error {
+	var loc string
+	var y int64
+	rq.sess.run(rq.metrics(), chromedp.Location(&loc), chromedp.Evaluate(`Math.round(window.scrollY)`, &y))
+	if loc != rq.url {
+		log.Printf("%s Restoring page %s, tab is on %s\n", rq.r.RemoteAddr, rq.url, loc)
+		if err := rq.sess.runOnce(chromedp.Navigate(rq.url), waitForRender()); err != nil {
+			return err
+		}
+		y = 0
+	}
+	if y != rq.scrollY {
+		return rq.sess.run(chromedp.Evaluate(fmt.Sprintf("window.scrollTo(window.scrollX, %d)", rq.scrollY), nil))
+	}
+	return nil
+}
+
 func mapServer(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s ISMAP Request for %s [%+v]\n", r.RemoteAddr, r.URL.Path, r.URL.RawQuery)
-	rq, ok := wrpCach.getMap(r.URL.Path)
-	rq.r = r
-	rq.w = w
-	if !ok {
-		fmt.Fprintf(w, "Unable to find map %s\n", r.URL.Path)
-		log.Printf("Unable to find map %s\n", r.URL.Path)
+	s, _ := sessions.get(w, r)
+	rq, err := parseToken(pathToken(r.URL.Path))
+	if err != nil {
+		// unusable map, show fresh capture of the current page
+		log.Printf("%s Unable to use map %s: %v, recapturing\n", r.RemoteAddr, r.URL.Path, err)
+		sessions.Lock()
+		rq = s.last
+		sessions.Unlock()
+		rq.r, rq.w, rq.sess = r, w, s
+		if len(rq.url) < 4 {
+			rq.parseForm()
+			rq.printUI(uiParams{})
+			return
+		}
+		if !s.lock() {
+			rq.printBusy()
+			return
+		}
+		defer s.unlock()
+		if rq.wrpMode == "html" {
+			rq.captureMarkdown()
+			return
+		}
+		rq.captureScreenshot()
 		return
 	}
+	rq.r, rq.w, rq.sess = r, w, s
 	n, err := fmt.Sscanf(r.URL.RawQuery, "%d,%d", &rq.mouseX, &rq.mouseY)
 	if err != nil || n != 2 {
 		fmt.Fprintf(w, "n=%d, err=%s\n", n, err)
 		log.Printf("%s ISMAP n=%d, err=%s\n", r.RemoteAddr, n, err)
 		return
 	}
+	rq.mouseY += rq.tileY
 	log.Printf("%s WrpReq from ISMAP: %+v\n", r.RemoteAddr, rq)
 	if len(rq.url) < 4 {
 		rq.printUI(uiParams{})
 		return
 	}
-	if dl := rq.navigate(); dl != nil {
+	if !rq.sess.lock() {
+		rq.printBusy()
+		return
+	}
+	defer rq.sess.unlock()
+	if err := rq.restorePage(); err != nil {
+		rq.printErr(err)
+		return
+	}
+	if rq.clickMode == "drag" && rq.dragX == 0 {
+		// first click of a drag only marks the start point
+		rq.dragX, rq.dragY = rq.mouseX, rq.mouseY
+		log.Printf("%s Drag start %d,%d\n", r.RemoteAddr, rq.dragX, rq.dragY)
+		rq.captureScreenshot()
+		return
+	}
+	dl, err := rq.navigate()
+	if dl != nil {
 		if rq.proxy {
 			writeDownload(w, dl)
 		} else {
@@ -399,10 +560,13 @@ func mapServer(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if err != nil {
+		rq.printErr(err)
+		return
+	}
 	if rq.proxy {
-		chromedp.Run(ctx, waitForRender())
 		var loc string
-		chromedp.Run(ctx, chromedp.Location(&loc))
+		rq.sess.run(waitForRender(), chromedp.Location(&loc))
 		loc = strings.Replace(loc, "https://", "http://", 1)
 		http.Redirect(w, r, loc, http.StatusFound)
 		return
@@ -410,14 +574,61 @@ func mapServer(w http.ResponseWriter, r *http.Request) {
 	rq.captureScreenshot()
 }
 
+// Re-render image no longer in cache from the state in its path. Images of
+// the capture, like other tiles of the page, are cached under the paths the
+// old page asks for so they don't recapture again.
+func recaptureImg(s *session, w http.ResponseWriter, r *http.Request) (bytes.Buffer, error) {
+	tok := pathToken(r.URL.Path)
+	rq, err := parseToken(tok)
+	if err != nil {
+		return bytes.Buffer{}, err
+	}
+	rq.r, rq.w, rq.sess = r, w, s
+	rq.nonce = tokenNonce(tok)
+	orig := rq
+	if !s.lock() {
+		return bytes.Buffer{}, fmt.Errorf("session busy")
+	}
+	defer s.unlock()
+	if err := rq.restorePage(); err != nil {
+		return bytes.Buffer{}, err
+	}
+	sc, err := rq.capture()
+	if err != nil {
+		return bytes.Buffer{}, err
+	}
+	tiles := sc.tiles
+	if len(tiles) == 0 {
+		tiles = []tile{{imgPath: sc.imgPath}}
+	}
+	ext := r.URL.Path[strings.LastIndex(r.URL.Path, ".")+1:]
+	var buf bytes.Buffer
+	for _, t := range tiles {
+		b, _ := s.cache.getImg(t.imgPath)
+		o := orig
+		o.tileY = t.offset
+		s.cache.addImg(fmt.Sprintf("/img/%s.%s", o.token(), ext), b)
+		if t.offset == orig.tileY {
+			buf = b
+		}
+	}
+	log.Printf("%s Recaptured %s, %d images\n", r.RemoteAddr, r.URL.Path, len(tiles))
+	return buf, nil
+}
+
 // TODO: merge this with html mode IMGZ
 func imgServerMap(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s IMG Request for %s\n", r.RemoteAddr, r.URL.Path)
-	imgBuf, ok := wrpCach.getImg(r.URL.Path)
+	s, _ := sessions.get(w, r)
+	imgBuf, ok := s.cache.getImg(r.URL.Path)
 	if !ok || imgBuf.Bytes() == nil {
-		fmt.Fprintf(w, "Unable to find image %s\n", r.URL.Path)
-		log.Printf("%s Unable to find image %s\n", r.RemoteAddr, r.URL.Path)
-		return
+		var err error
+		imgBuf, err = recaptureImg(s, w, r)
+		if err != nil {
+			fmt.Fprintf(w, "Unable to find image %s\n", r.URL.Path)
+			log.Printf("%s Unable to find image %s: %v\n", r.RemoteAddr, r.URL.Path, err)
+			return
+		}
 	}
 	switch {
 	case strings.HasSuffix(r.URL.Path, ".gif"):
@@ -427,10 +638,19 @@ func imgServerMap(w http.ResponseWriter, r *http.Request) {
 	case strings.HasSuffix(r.URL.Path, ".jpg"):
 		w.Header().Set("Content-Type", "image/jpeg")
 	}
-	w.Header().Set("Content-Length", strconv.Itoa(len(imgBuf.Bytes())))
+	// an unchanged shot reused by refresh is revalidated, not sent again
+	h := fnv.New64a()
+	h.Write(imgBuf.Bytes())
+	etag := fmt.Sprintf("\"%x\"", h.Sum64())
+	w.Header().Set("ETag", etag)
 	w.Header().Set("Cache-Control", "max-age=0")
 	w.Header().Set("Expires", "-1")
 	w.Header().Set("Pragma", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(imgBuf.Bytes())))
 	w.Write(imgBuf.Bytes())
 	w.(http.Flusher).Flush()
 }
@@ -0,0 +1,150 @@
+// WRP key chord syntax for the K field
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// Named keys usable in {chords}, lower case
+var keyNames = map[string]string{
+	"tab":       kb.Tab,
+	"esc":       kb.Escape,
+	"escape":    kb.Escape,
+	"enter":     kb.Enter,
+	"return":    kb.Enter,
+	"bs":        kb.Backspace,
+	"backspace": kb.Backspace,
+	"del":       kb.Delete,
+	"delete":    kb.Delete,
+	"ins":       kb.Insert,
+	"insert":    kb.Insert,
+	"home":      kb.Home,
+	"end":       kb.End,
+	"pgup":      kb.PageUp,
+	"pageup":    kb.PageUp,
+	"pgdn":      kb.PageDown,
+	"pagedown":  kb.PageDown,
+	"up":        kb.ArrowUp,
+	"down":      kb.ArrowDown,
+	"left":      kb.ArrowLeft,
+	"right":     kb.ArrowRight,
+	"space":     " ",
+	"f1":        kb.F1,
+	"f2":        kb.F2,
+	"f3":        kb.F3,
+	"f4":        kb.F4,
+	"f5":        kb.F5,
+	"f6":        kb.F6,
+	"f7":        kb.F7,
+	"f8":        kb.F8,
+	"f9":        kb.F9,
+	"f10":       kb.F10,
+	"f11":       kb.F11,
+	"f12":       kb.F12,
+}
+
+var keyMods = map[string]input.Modifier{
+	"ctrl":    input.ModifierCtrl,
+	"control": input.ModifierCtrl,
+	"alt":     input.ModifierAlt,
+	"opt":     input.ModifierAlt,
+	"shift":   input.ModifierShift,
+	"meta":    input.ModifierMeta,
+	"cmd":     input.ModifierMeta,
+	"win":     input.ModifierMeta,
+}
+
+// Parse chord like Ctrl+Shift+Tab, false if it is not one
+func parseChord(c string) ([]*input.DispatchKeyEventParams, bool) {
+	p := strings.Split(c, "+")
+	if strings.HasSuffix(c, "++") { // {Ctrl++}
+		p = append(p[:len(p)-2], "+")
+	}
+	var mods input.Modifier
+	for _, m := range p[:len(p)-1] {
+		mod, ok := keyMods[strings.ToLower(m)]
+		if !ok {
+			return nil, false
+		}
+		mods |= mod
+	}
+	k := p[len(p)-1]
+	if n, ok := keyNames[strings.ToLower(k)]; ok {
+		k = n
+	} else if len(p) > 1 {
+		// letter case comes from Shift only, {Ctrl+L} is Ctrl+l
+		k = strings.ToLower(k)
+		if mods&input.ModifierShift != 0 {
+			k = strings.ToUpper(k)
+		}
+	}
+	if len([]rune(k)) != 1 {
+		return nil, false
+	}
+	evs := kb.Encode([]rune(k)[0])
+	var r []*input.DispatchKeyEventParams
+	for _, e := range evs {
+		// no text with shortcuts, eg. Ctrl+L must not type l
+		if e.Type == input.KeyChar && mods&^input.ModifierShift != 0 {
+			continue
+		}
+		e.Modifiers |= mods
+		r = append(r, e)
+	}
+	return r, true
+}
+
+// Convert K field text to key events. Text in {} is a key chord like {Tab},
+// {Esc}, {F5}, {Ctrl+L} or {Shift+Tab}, {{ is a literal brace. Anything
+// else including unknown chords is typed as is.
+func parseKeys(s string) []*input.DispatchKeyEventParams {
+	var r []*input.DispatchKeyEventParams
+	typed := func(t string) {
+		for _, c := range t {
+			r = append(r, kb.Encode(c)...)
+		}
+	}
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			typed(s)
+			break
+		}
+		typed(s[:i])
+		s = s[i:]
+		if strings.HasPrefix(s, "{{") {
+			typed("{")
+			s = s[2:]
+			continue
+		}
+		j := strings.IndexByte(s[1:], '}')
+		if j < 0 {
+			typed(s)
+			break
+		}
+		if evs, ok := parseChord(s[1 : j+1]); ok {
+			r = append(r, evs...)
+		} else {
+			typed(s[:j+2])
+		}
+		s = s[j+2:]
+	}
+	return r
+}
+
+func keyChords(s string) chromedp.Action {
+	evs := parseKeys(s)
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for _, e := range evs {
+			if err := e.Do(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
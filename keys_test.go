@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/chromedp/cdproto/input"
+)
+
+func TestParseKeys(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		key  string
+		mods input.Modifier
+		text string
+	}{
+		{"{Ctrl+L}", "l", input.ModifierCtrl, ""},
+		{"{ctrl+l}", "l", input.ModifierCtrl, ""},
+		{"{Ctrl+Shift+L}", "L", input.ModifierCtrl | input.ModifierShift, ""},
+		{"{Shift+Tab}", "Tab", input.ModifierShift, ""},
+		{"{{", "{", input.ModifierShift, "{"},
+		{"{Ctrl++}", "+", input.ModifierCtrl | input.ModifierShift, ""},
+	} {
+		evs := parseKeys(c.in)
+		if len(evs) == 0 {
+			t.Errorf("%s: no key events", c.in)
+			continue
+		}
+		var text string
+		for _, e := range evs {
+			if e.Key != c.key || e.Modifiers != c.mods {
+				t.Errorf("%s: got key %q modifiers %d, want %q %d", c.in, e.Key, e.Modifiers, c.key, c.mods)
+			}
+			text += e.Text
+		}
+		if text != c.text {
+			t.Errorf("%s: typed %q, want %q", c.in, text, c.text)
+		}
+	}
+}
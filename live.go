@@ -0,0 +1,158 @@
+// WRP live view using Netscape server push
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// Serve /live/<token> page with the pushed image, clicks go to the usual
+// map, and /live/<token>.<ext> multipart/x-mixed-replace image stream
+func liveServer(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s Live Request for %s\n", r.RemoteAddr, r.URL.Path)
+	s, _ := sessions.get(w, r)
+	tok := pathToken(r.URL.Path)
+	rq, err := parseToken(tok)
+	if err != nil {
+		log.Printf("%s Unable to use live view %s: %v\n", r.RemoteAddr, r.URL.Path, err)
+		http.NotFound(w, r)
+		return
+	}
+	rq.r, rq.w, rq.sess = r, w, s
+	if !strings.Contains(r.URL.Path[strings.LastIndex(r.URL.Path, "/"):], ".") {
+		ext := rq.imgType
+		if ext == "gip" {
+			ext = "gif"
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "max-age=0")
+		fmt.Fprintf(w, "<HTML><HEAD><TITLE>Live %s</TITLE></HEAD><BODY BGCOLOR=\"%s\">"+
+			"<A HREF=\"/map/%s.map\"><IMG SRC=\"/live/%s.%s\" BORDER=\"0\" ISMAP></A>"+
+			"<P><A HREF=\"%s\">Back to WRP</A></P></BODY></HTML>",
+			rq.url, *bgColor, tok, tok, ext, rq.uiQuery(rq.url, "St"))
+		return
+	}
+	if !s.lock() {
+		http.Error(w, "Session busy", http.StatusServiceUnavailable)
+		return
+	}
+	err = rq.restorePage()
+	t := s.tab()
+	s.unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	rq.streamLive(t)
+}
+
+// Push screencast frames until the client goes away or -live expires.
+// Frames come at most -fps per second and unchanged ones are skipped.
+func (rq *wrpReq) streamLive(t *tab) {
+	rq.sess.streams.Add(1)
+	defer func() {
+		sessions.Lock()
+		rq.sess.seen = time.Now()
+		sessions.Unlock()
+		rq.sess.streams.Add(-1)
+	}()
+	frames := make(chan []byte, 1)
+	lctx, lcncl := context.WithCancel(t.ctx)
+	defer lcncl()
+	chromedp.ListenTarget(lctx, func(ev any) {
+		e, ok := ev.(*page.EventScreencastFrame)
+		if !ok {
+			return
+		}
+		go chromedp.Run(lctx, page.ScreencastFrameAck(e.SessionID))
+		buf, err := base64.StdEncoding.DecodeString(e.Data)
+		if err != nil {
+			return
+		}
+		// keep only the latest frame
+		select {
+		case <-frames:
+		default:
+		}
+		select {
+		case frames <- buf:
+		default:
+		}
+	})
+	err := rq.sess.runTab(t, page.StartScreencast().
+		WithFormat(page.ScreencastFormatPng).
+		WithMaxWidth(rq.width).
+		WithMaxHeight(rq.height))
+	if err != nil {
+		log.Printf("%s Unable to start screencast: %v\n", rq.r.RemoteAddr, err)
+		http.Error(rq.w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer rq.sess.runTab(t, page.StopScreencast())
+	mw := multipart.NewWriter(rq.w)
+	defer mw.Close()
+	rq.w.Header().Set("Content-Type", "multipart/x-mixed-replace;boundary="+mw.Boundary())
+	rq.w.Header().Set("Cache-Control", "no-cache")
+	ctype := "image/" + rq.imgType
+	switch rq.imgType {
+	case "gip":
+		ctype = "image/gif"
+	case "jpg":
+		ctype = "image/jpeg"
+	}
+	tick := time.NewTicker(time.Duration(float64(time.Second) / *liveFPS))
+	defer tick.Stop()
+	end := time.After(*liveMax)
+	var last uint64
+	var n int
+	log.Printf("%s Live view of %s started\n", rq.r.RemoteAddr, rq.url)
+	for {
+		select {
+		case <-rq.r.Context().Done():
+			log.Printf("%s Live view ended by client after %d frames\n", rq.r.RemoteAddr, n)
+			return
+		case <-end:
+			log.Printf("%s Live view timed out after %d frames\n", rq.r.RemoteAddr, n)
+			return
+		case buf := <-frames:
+			h := fnv.New64a()
+			h.Write(buf)
+			if h.Sum64() == last {
+				continue
+			}
+			last = h.Sum64()
+			i, _, err := image.Decode(bytes.NewReader(buf))
+			if err != nil {
+				continue
+			}
+			enc, err := rq.encodeImage(i)
+			if err != nil {
+				log.Printf("%s Failed to encode live frame: %v\n", rq.r.RemoteAddr, err)
+				return
+			}
+			p, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {ctype}})
+			if err != nil {
+				return
+			}
+			if _, err := p.Write(enc.Bytes()); err != nil {
+				return
+			}
+			rq.w.(http.Flusher).Flush()
+			n++
+			<-tick.C
+		}
+	}
+}
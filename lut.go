@@ -0,0 +1,141 @@
+// WRP fast palette conversion using a nearest color lookup table
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// 18 bit color cube, 6 bits per channel
+const lutBits = 6
+
+// Nearest palette index for each cell of the color cube, filled on first use.
+// Entries hold index+1, 0 means not looked up yet.
+type paletteLUT struct {
+	rgb [][3]int32
+	idx []uint32
+}
+
+func newPaletteLUT(p color.Palette) *paletteLUT {
+	l := &paletteLUT{
+		rgb: make([][3]int32, len(p)),
+		idx: make([]uint32, 1<<(3*lutBits)),
+	}
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		l.rgb[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+	}
+	return l
+}
+
+// LUTs of fixed palettes are kept, their cells fill up over many images.
+// Quantized palettes differ per image and get a fresh one.
+var sharedLUTs sync.Map
+
+func lutFor(p color.Palette) *paletteLUT {
+	if v, ok := sharedLUTs.Load(&p[0]); ok {
+		return v.(*paletteLUT)
+	}
+	return newPaletteLUT(p)
+}
+
+// Keep the LUT of fixed palette p for later images
+func shareLUT(p color.Palette) {
+	if _, ok := sharedLUTs.Load(&p[0]); !ok {
+		sharedLUTs.LoadOrStore(&p[0], newPaletteLUT(p))
+	}
+}
+
+func (l *paletteLUT) index(r, g, b uint8) uint8 {
+	const s = 8 - lutBits
+	c := int(r>>s)<<(2*lutBits) | int(g>>s)<<lutBits | int(b>>s)
+	if v := atomic.LoadUint32(&l.idx[c]); v != 0 {
+		return uint8(v - 1)
+	}
+	// nearest to the cell center, same value whichever goroutine gets here first
+	cr, cg, cb := int32(r>>s)<<s|1<<(s-1), int32(g>>s)<<s|1<<(s-1), int32(b>>s)<<s|1<<(s-1)
+	best, bestD := 0, int32(1<<30)
+	for i, p := range l.rgb {
+		dr, dg, db := cr-p[0], cg-p[1], cb-p[2]
+		if d := dr*dr + dg*dg + db*db; d < bestD {
+			best, bestD = i, d
+			if d == 0 {
+				break
+			}
+		}
+	}
+	atomic.StoreUint32(&l.idx[c], uint32(best+1))
+	return uint8(best)
+}
+
+// Image as 8 bit RGBA pixels, without copying when it already is one
+func rgbaImage(i image.Image) *image.RGBA {
+	switch t := i.(type) {
+	case *image.RGBA:
+		return t
+	}
+	b := i.Bounds()
+	r := image.NewRGBA(b)
+	draw.Draw(r, b, i, b.Min, draw.Src)
+	return r
+}
+
+// Run fn over rows of the rectangle split between CPUs
+func parallelRows(b image.Rectangle, fn func(y0, y1 int)) {
+	n := min(runtime.NumCPU(), b.Dy())
+	if n < 2 {
+		fn(b.Min.Y, b.Max.Y)
+		return
+	}
+	var wg sync.WaitGroup
+	step := (b.Dy() + n - 1) / n
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			fn(y0, y1)
+		}(y, min(y+step, b.Max.Y))
+	}
+	wg.Wait()
+}
+
+// Map pixels to the nearest palette color, adding threshold matrix m scaled
+// by spread for ordered dithering if m is not nil
+func remapImage(o *image.Paletted, i image.Image, m [][]float64, spread float64) {
+	src := rgbaImage(i)
+	l := lutFor(o.Palette)
+	b := o.Rect
+	var th [][]int32
+	if m != nil {
+		n := float64(len(m) * len(m))
+		th = make([][]int32, len(m))
+		for y, row := range m {
+			th[y] = make([]int32, len(row))
+			for x, v := range row {
+				th[y][x] = int32(((v+0.5)/n - 0.5) * spread)
+			}
+		}
+	}
+	parallelRows(b, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sp := src.Pix[src.PixOffset(b.Min.X, y):]
+			op := o.Pix[o.PixOffset(b.Min.X, y):]
+			for x := 0; x < b.Dx(); x++ {
+				r, g, bl := sp[x*4], sp[x*4+1], sp[x*4+2]
+				if th != nil {
+					t := th[(y-b.Min.Y)%len(th)][x%len(th)]
+					r, g, bl = add8(r, t), add8(g, t), add8(bl, t)
+				}
+				op[x] = l.index(r, g, bl)
+			}
+		}
+	})
+}
+
+func add8(v uint8, t int32) uint8 {
+	return uint8(clamp8(int32(v) + t))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/ericpauley/go-quantize/quantize"
+)
+
+// Synthetic 1152x4000 page, flat color bands with gradient blocks
+func benchPage() *image.RGBA {
+	i := image.NewRGBA(image.Rect(0, 0, 1152, 4000))
+	for y := 0; y < 4000; y++ {
+		band := color.RGBA{uint8(y / 8 * 37), uint8(y / 8 * 91), uint8(y / 8 * 13), 0xff}
+		for x := 0; x < 1152; x++ {
+			if (x/16+y/16)%5 == 0 {
+				i.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 0xff})
+			} else {
+				i.SetRGBA(x, y, band)
+			}
+		}
+	}
+	return i
+}
+
+func benchPalette(i image.Image, n int) color.Palette {
+	q := quantize.MedianCutQuantizer{}
+	return q.Quantize(make([]color.Color, 0, n), i)
+}
+
+// Conversion loop used by gifPalette before the LUT
+func setLoop(i image.Image, p color.Palette) *image.Paletted {
+	b := i.Bounds()
+	o := image.NewPaletted(b, p)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			o.Set(x, y, i.At(x, y))
+		}
+	}
+	return o
+}
+
+func benchConvert(b *testing.B, n int, conv func(image.Image, color.Palette) *image.Paletted) {
+	i := benchPage()
+	p := benchPalette(i, n)
+	b.ResetTimer()
+	for range b.N {
+		conv(i, p)
+	}
+}
+
+func remapLoop(i image.Image, p color.Palette) *image.Paletted {
+	o := image.NewPaletted(i.Bounds(), p)
+	remapImage(o, i, nil, 0)
+	return o
+}
+
+func stdFS(i image.Image, p color.Palette) *image.Paletted {
+	o := image.NewPaletted(i.Bounds(), p)
+	draw.FloydSteinberg.Draw(o, o.Rect, i, image.Point{})
+	return o
+}
+
+func lutFS(i image.Image, p color.Palette) *image.Paletted {
+	o := image.NewPaletted(i.Bounds(), p)
+	diffuse(o, i, fsTaps, 4)
+	return o
+}
+
+func BenchmarkSetLoop256(b *testing.B)   { benchConvert(b, 256, setLoop) }
+func BenchmarkRemap256(b *testing.B)     { benchConvert(b, 256, remapLoop) }
+func BenchmarkSetLoop16(b *testing.B)    { benchConvert(b, 16, setLoop) }
+func BenchmarkRemap16(b *testing.B)      { benchConvert(b, 16, remapLoop) }
+func BenchmarkDrawFS256(b *testing.B)    { benchConvert(b, 256, stdFS) }
+func BenchmarkDiffuseFS256(b *testing.B) { benchConvert(b, 256, lutFS) }
+func BenchmarkDrawFS2(b *testing.B)      { benchConvert(b, 2, stdFS) }
+func BenchmarkDiffuseFS2(b *testing.B)   { benchConvert(b, 2, lutFS) }
@@ -0,0 +1,72 @@
+// WRP mouse gestures for ISMAP clicks
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	dragSteps     = 10
+	defWheelDelta = 300
+)
+
+// Mouse events for a click on the image at page coordinates x,y
+func (rq *wrpReq) mouseAction(x, y float64) chromedp.Action {
+	switch rq.clickMode {
+	case "double":
+		return chromedp.Tasks{
+			chromedp.MouseClickXY(x, y),
+			chromedp.MouseClickXY(x, y, chromedp.ClickCount(2)),
+		}
+	case "right":
+		return chromedp.MouseClickXY(x, y, chromedp.ButtonRight)
+	case "copy":
+		return rq.copyAt(x, y)
+	case "hover":
+		return chromedp.MouseEvent(input.MouseMoved, x, y)
+	case "wheel":
+		// scrolls the element under the pointer, like inner panes and maps
+		return chromedp.Tasks{
+			input.DispatchMouseEvent(input.MouseWheel, x, y).WithDeltaX(0).WithDeltaY(float64(rq.wheelDelta)),
+			chromedp.Sleep(300 * time.Millisecond),
+		}
+	case "drag":
+		fx, fy := float64(rq.dragX)/rq.zoom, float64(rq.dragY)/rq.zoom
+		rq.dragX, rq.dragY = 0, 0
+		log.Printf("%s Mouse Drag %.0f,%.0f to %.0f,%.0f\n", rq.r.RemoteAddr, fx, fy, x, y)
+		return chromedp.ActionFunc(func(ctx context.Context) error {
+			if err := chromedp.MouseEvent(input.MouseMoved, fx, fy).Do(ctx); err != nil {
+				return err
+			}
+			if err := chromedp.MouseEvent(input.MousePressed, fx, fy, chromedp.ButtonLeft).Do(ctx); err != nil {
+				return err
+			}
+			for i := 1; i <= dragSteps; i++ {
+				mx := fx + (x-fx)*float64(i)/dragSteps
+				my := fy + (y-fy)*float64(i)/dragSteps
+				if err := input.DispatchMouseEvent(input.MouseMoved, mx, my).WithButton(input.Left).WithButtons(1).Do(ctx); err != nil {
+					return err
+				}
+			}
+			return chromedp.MouseEvent(input.MouseReleased, x, y, chromedp.ButtonLeft).Do(ctx)
+		})
+	}
+	return chromedp.MouseClickXY(x, y)
+}
+
+// Scroll the page sideways by most of the screen width, dir is -1 or 1
+func (rq *wrpReq) scrollX(dir int) chromedp.Action {
+	dx := dir * int(float64(rq.width)/rq.zoom*0.8)
+	return chromedp.Evaluate(fmt.Sprintf("window.scrollBy(%d, 0)", dx), nil)
+}
+
+// Clicks in these modes must go through the ISMAP, not USEMAP links
+func (rq *wrpReq) plainClicks() bool {
+	return rq.clickMode == "" || rq.clickMode == "click"
+}
@@ -0,0 +1,108 @@
+// WRP fixed system palettes for vintage displays
+package main
+
+import (
+	"image/color"
+	"image/color/palette"
+	"strconv"
+	"strings"
+)
+
+func rgbPalette(c ...uint32) color.Palette {
+	p := make(color.Palette, len(c))
+	for i, v := range c {
+		p[i] = color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+	}
+	return p
+}
+
+// Windows 16 color VGA palette
+var win16 = rgbPalette(
+	0x000000, 0x800000, 0x008000, 0x808000, 0x000080, 0x800080, 0x008080, 0xc0c0c0,
+	0x808080, 0xff0000, 0x00ff00, 0xffff00, 0x0000ff, 0xff00ff, 0x00ffff, 0xffffff,
+)
+
+// Windows 20 static system colors
+var win20 = append(rgbPalette(0xc0dcc0, 0xa6caf0, 0xfffbf0, 0xa0a0a4), win16...)
+
+var cga = rgbPalette(
+	0x000000, 0x0000aa, 0x00aa00, 0x00aaaa, 0xaa0000, 0xaa00aa, 0xaa5500, 0xaaaaaa,
+	0x555555, 0x5555ff, 0x55ff55, 0x55ffff, 0xff5555, 0xff55ff, 0xffff55, 0xffffff,
+)
+
+// EGA 64 colors, 2 bits per channel
+func egaPalette() color.Palette {
+	var p color.Palette
+	for r := 0; r < 4; r++ {
+		for g := 0; g < 4; g++ {
+			for b := 0; b < 4; b++ {
+				p = append(p, color.RGBA{uint8(r * 0x55), uint8(g * 0x55), uint8(b * 0x55), 0xff})
+			}
+		}
+	}
+	return p
+}
+
+// Mac OS 8 bit system palette, 6x6x6 cube without black, ramps of red,
+// green, blue and gray, then black
+func macPalette() color.Palette {
+	var p color.Palette
+	for r := 5; r >= 0; r-- {
+		for g := 5; g >= 0; g-- {
+			for b := 5; b >= 0; b-- {
+				if r+g+b > 0 {
+					p = append(p, color.RGBA{uint8(r * 0x33), uint8(g * 0x33), uint8(b * 0x33), 0xff})
+				}
+			}
+		}
+	}
+	ramp := []uint8{0xee, 0xdd, 0xbb, 0xaa, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11}
+	for _, v := range ramp {
+		p = append(p, color.RGBA{v, 0, 0, 0xff})
+	}
+	for _, v := range ramp {
+		p = append(p, color.RGBA{0, v, 0, 0xff})
+	}
+	for _, v := range ramp {
+		p = append(p, color.RGBA{0, 0, v, 0xff})
+	}
+	for _, v := range ramp {
+		p = append(p, color.RGBA{v, v, v, 0xff})
+	}
+	return append(p, color.RGBA{0, 0, 0, 0xff})
+}
+
+func grayPalette(n int) color.Palette {
+	p := make(color.Palette, n)
+	for i := range p {
+		v := uint8(i * 255 / (n - 1))
+		p[i] = color.RGBA{v, v, v, 0xff}
+	}
+	return p
+}
+
+var fixedPalettes = map[string]color.Palette{
+	"web216": palette.WebSafe,
+	"win16":  win16,
+	"win20":  win20,
+	"mac256": macPalette(),
+	"ega":    egaPalette(),
+	"cga":    cga,
+	"gray16": grayPalette(16),
+	"gray4":  grayPalette(4),
+}
+
+// Named fixed palette for the c form field, grayN is N levels of gray.
+// Only listed palettes keep their lookup table, other gray levels get a
+// fresh one per image like quantized palettes.
+func fixedPalette(name string) (color.Palette, bool) {
+	if p, ok := fixedPalettes[name]; ok {
+		shareLUT(p)
+		return p, true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "gray"))
+	if err != nil || !strings.HasPrefix(name, "gray") || n < 2 || n > 256 {
+		return nil, false
+	}
+	return grayPalette(n), true
+}
@@ -0,0 +1,134 @@
+// WRP chromedp action executor with retries
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	runAttempts = 3
+	runBackoff  = 500 * time.Millisecond
+)
+
+type errKind int
+
+const (
+	errTransient  errKind = iota // worth retrying
+	errNavigation                // page failed to load, retry won't help
+	errFatal                     // tab or browser is gone
+)
+
+func (k errKind) String() string {
+	switch k {
+	case errTransient:
+		return "transient"
+	case errNavigation:
+		return "navigation"
+	}
+	return "fatal"
+}
+
+// Error returned by session.run
+type runError struct {
+	kind     errKind
+	attempts int
+	err      error
+}
+
+func (e *runError) Error() string {
+	return fmt.Sprintf("%v error after %d attempt(s): %v", e.kind, e.attempts, e.err)
+}
+
+func (e *runError) Unwrap() error {
+	return e.err
+}
+
+func classifyErr(err error) errKind {
+	s := err.Error()
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, chromedp.ErrInvalidContext),
+		errors.Is(err, chromedp.ErrInvalidTarget),
+		errors.Is(err, chromedp.ErrChannelClosed):
+		return errFatal
+	case strings.HasPrefix(s, "page load error"), strings.Contains(s, "net::ERR_"):
+		return errNavigation
+	}
+	return errTransient
+}
+
+// Run chromedp actions in the current session tab
+func (s *session) run(actions ...chromedp.Action) error {
+	return s.runTab(s.tab(), actions...)
+}
+
+// Run chromedp actions that must not be repeated, like clicks, keys, form
+// submits and navigation, in the current tab with a single attempt
+func (s *session) runOnce(actions ...chromedp.Action) error {
+	return s.attempt(s.tab(), 1, actions...)
+}
+
+// Run chromedp actions in a tab, retrying transient errors with exponential
+// backoff. Only use for actions that are safe to repeat.
+func (s *session) runTab(t *tab, actions ...chromedp.Action) error {
+	return s.attempt(t, runAttempts, actions...)
+}
+
+// Run chromedp actions up to n times. Each attempt is bound by the -rt flag,
+// attempts that time out are not repeated so the session is not held for
+// longer than that. Fatal errors reopen the tab so the next request gets
+// a working one.
+func (s *session) attempt(t *tab, n int, actions ...chromedp.Action) error {
+	wait := runBackoff
+	for a := 1; ; a++ {
+		tctx, tcncl := context.WithTimeout(t.ctx, *runTimeout)
+		err := chromedp.Run(tctx, actions...)
+		tcncl()
+		if err == nil {
+			return nil
+		}
+		k := classifyErr(err)
+		log.Printf("%s Session %s %v error, attempt %d/%d: %v", s.addr, s.id, k, a, n, err)
+		switch {
+		case k == errFatal:
+			s.reopenTab(t)
+			return &runError{kind: k, attempts: a, err: err}
+		case k == errNavigation, a >= n, errors.Is(err, context.DeadlineExceeded):
+			return &runError{kind: k, attempts: a, err: err}
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (rq *wrpReq) printErr(err error) {
+	log.Printf("%s Browser error: %v\n", rq.r.RemoteAddr, err)
+	msg := fmt.Sprintf("Error: %v", err)
+	var re *runError
+	if errors.As(err, &re) {
+		switch re.kind {
+		case errNavigation:
+			msg = fmt.Sprintf("Unable to load %s: %v", rq.url, re.err)
+		case errTransient:
+			msg = fmt.Sprintf("Browser did not respond after %d attempt(s), try again: %v", re.attempts, re.err)
+		case errFatal:
+			msg = fmt.Sprintf("Browser tab was lost and has been reopened, try again: %v", re.err)
+		}
+	}
+	msg = html.EscapeString(msg)
+	if rq.proxy {
+		rq.w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(rq.w, "<HTML><HEAD>%s<TITLE>WRP Error</TITLE></HEAD><BODY BGCOLOR=\"%s\">%s</BODY></HTML>",
+			rq.baseTag(), *bgColor, msg)
+		return
+	}
+	rq.printUI(uiParams{text: "<P>" + msg + "</P>"})
+}
@@ -0,0 +1,108 @@
+// WRP scroll position, page numbers and the scroll strip
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+const stripWidth = 16
+
+// Viewport height in CSS pixels, 0 if the whole page is captured
+func (rq *wrpReq) viewH() int64 {
+	if rq.height == 0 || rq.wrpMode == "tiles" {
+		return 0
+	}
+	return int64(float64(rq.height) / rq.zoom)
+}
+
+// Current page and number of pages of viewport height
+func (rq *wrpReq) pageOf() (int64, int64) {
+	vh := rq.viewH()
+	if vh == 0 || rq.pageH == 0 {
+		return 1, 1
+	}
+	return rq.scrollY/vh + 1, (rq.pageH + vh - 1) / vh
+}
+
+// Scroll to the offset requested with sy or pg before capturing
+func (rq *wrpReq) applyScroll() {
+	if !rq.gotoSet {
+		return
+	}
+	rq.gotoSet = false
+	log.Printf("%s Scroll to %d\n", rq.r.RemoteAddr, rq.gotoY)
+	rq.sess.run(chromedp.Evaluate(fmt.Sprintf("window.scrollTo(window.scrollX, %d)", rq.gotoY), nil))
+}
+
+// Vertical bar showing the viewport position within the page
+func (rq *wrpReq) stripImage() *image.Paletted {
+	h := int(rq.height)
+	i := image.NewPaletted(image.Rect(0, 0, stripWidth, h), color.Palette{
+		color.RGBA{0xc0, 0xc0, 0xc0, 0xff},
+		color.RGBA{0x60, 0x60, 0x60, 0xff},
+		color.RGBA{0x00, 0x00, 0x00, 0xff},
+	})
+	vh := rq.viewH()
+	if rq.pageH > 0 {
+		top := int(rq.scrollY * int64(h) / rq.pageH)
+		bot := max(int((rq.scrollY+vh)*int64(h)/rq.pageH), top+4)
+		for y := top; y < min(bot, h); y++ {
+			for x := 2; x < stripWidth-2; x++ {
+				i.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	for y := 0; y < h; y++ {
+		i.SetColorIndex(0, y, 2)
+	}
+	return i
+}
+
+// Serve /strip/<token>.gif image and /strip/<token>.map?x,y clicks, which
+// scroll the page to the clicked position
+func stripServer(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s Strip Request for %s [%+v]\n", r.RemoteAddr, r.URL.Path, r.URL.RawQuery)
+	s, _ := sessions.get(w, r)
+	rq, err := parseToken(pathToken(r.URL.Path))
+	if err != nil || rq.viewH() == 0 {
+		log.Printf("%s Unable to use strip %s: %v\n", r.RemoteAddr, r.URL.Path, err)
+		http.NotFound(w, r)
+		return
+	}
+	rq.r, rq.w, rq.sess = r, w, s
+	if strings.HasSuffix(r.URL.Path, ".gif") {
+		var buf bytes.Buffer
+		gif.Encode(&buf, rq.stripImage(), nil)
+		w.Header().Set("Content-Type", "image/gif")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+		return
+	}
+	var x, y int64
+	if n, err := fmt.Sscanf(r.URL.RawQuery, "%d,%d", &x, &y); err != nil || n != 2 {
+		log.Printf("%s Strip n=%d, err=%s\n", r.RemoteAddr, n, err)
+		http.NotFound(w, r)
+		return
+	}
+	if !s.lock() {
+		rq.printBusy()
+		return
+	}
+	defer s.unlock()
+	if err := rq.restorePage(); err != nil {
+		rq.printErr(err)
+		return
+	}
+	rq.gotoY, rq.gotoSet = max(y*rq.pageH/rq.height-rq.viewH()/2, 0), true
+	rq.captureScreenshot()
+}
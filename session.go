@@ -0,0 +1,302 @@
+// WRP per-client browser sessions
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+const sessCookie = "wrpsid"
+
+const sessExpiredText = `<P>Your browser session has expired and a new one has been started. Click <B>Go</B> to continue.</P>`
+
+const sessRestartedText = `<P>The browser has crashed and was restarted, your page has been reloaded.</P>`
+
+// Browser session, each client gets its own chromedp targets
+type session struct {
+	id    string
+	addr  string
+	tabs  []*tab
+	cur   int
+	cache wrpCache
+	imgs  imageStore
+	dl    dlState
+	seen  time.Time
+	busy  chan struct{}
+	proc  *browserProc
+	last  wrpReq
+	hist  []string
+	// last encoded screenshot, guarded by the busy lock
+	prevKey  uint64
+	prevShot *screenshot
+	// client has sent the session cookie back, guarded by the store lock
+	cookieOK bool
+	// set when the browser was relaunched under the session
+	restarted atomic.Bool
+	// number of live view streams
+	streams atomic.Int32
+}
+
+type sessionStore struct {
+	sync.Mutex
+	byID    map[string]*session
+	byAddr  map[string]*session
+	expired map[string]time.Time
+}
+
+var sessions = sessionStore{
+	byID:    make(map[string]*session),
+	byAddr:  make(map[string]*session),
+	expired: make(map[string]time.Time),
+}
+
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func sessionID(r *http.Request) string {
+	c, err := r.Cookie(sessCookie)
+	if err != nil || c.Value == "" || len(c.Value) > 64 {
+		return ""
+	}
+	return c.Value
+}
+
+func newSession(id, addr string) *session {
+	s := &session{id: id, addr: addr, seen: time.Now()}
+	s.cache.imgs = make(map[string]cachedImg)
+	s.imgs.img = make(map[string]imageContainer)
+	s.dl.notify = make(chan struct{}, 1)
+	s.busy = make(chan struct{}, 1)
+	s.proc = browsers.pick()
+	s.tabs = []*tab{s.openTab()}
+	log.Printf("%s New session %s on browser #%d", addr, id, s.proc.n)
+	return s
+}
+
+// Find existing session for the client, nil if none
+func (ss *sessionStore) find(r *http.Request) *session {
+	ss.Lock()
+	defer ss.Unlock()
+	s, ok := ss.byID[sessionID(r)]
+	if !ok {
+		s, ok = ss.byAddr[clientAddr(r)]
+	}
+	if !ok {
+		return nil
+	}
+	s.seen = time.Now()
+	return s
+}
+
+// Get or create session for the client, identified by cookie or IP address
+// for cookie-less browsers and proxy mode. Sets the cookie for new clients.
+// A request without cookie only joins the IP's session in proxy mode or if
+// that session never got its cookie back, otherwise it is another browser
+// behind the same address. Returns true if the client's previous session
+// has expired.
+func (ss *sessionStore) get(w http.ResponseWriter, r *http.Request) (*session, bool) {
+	ss.Lock()
+	addr := clientAddr(r)
+	id := sessionID(r)
+	if s, ok := ss.byID[id]; ok {
+		s.seen = time.Now()
+		s.cookieOK = true
+		ss.Unlock()
+		return s, false
+	}
+	if id == "" {
+		if s, ok := ss.byAddr[addr]; ok && (isProxyRequest(r) || !s.cookieOK) {
+			s.seen = time.Now()
+			ss.setCookie(w, r, s)
+			ss.Unlock()
+			return s, false
+		}
+		id = shortuuid.New()
+	}
+	_, expired := ss.expired[id]
+	if !expired {
+		_, expired = ss.expired[addr]
+	}
+	ss.Unlock()
+	// opening the tab can take long on a busy browser, don't hold up others
+	s := newSession(id, addr)
+	ss.Lock()
+	defer ss.Unlock()
+	if o, ok := ss.byID[id]; ok {
+		// another request of the client got there first
+		s.closeTabs()
+		browsers.release(s.proc)
+		o.seen = time.Now()
+		return o, false
+	}
+	delete(ss.expired, id)
+	delete(ss.expired, addr)
+	if *maxSess > 0 && len(ss.byID) >= *maxSess {
+		ss.evictLRU()
+	}
+	ss.byID[id] = s
+	if old, ok := ss.byAddr[addr]; !ok || old.cookieOK {
+		ss.byAddr[addr] = s
+	}
+	ss.setCookie(w, r, s)
+	return s, expired
+}
+
+// Serialize browser actions within the session, false if still busy after -wait
+func (s *session) lock() bool {
+	select {
+	case s.busy <- struct{}{}:
+		return true
+	case <-time.After(*busyWait):
+		return false
+	}
+}
+
+func (s *session) unlock() {
+	<-s.busy
+}
+
+// Tell the client that a previous request is still being processed
+func (rq *wrpReq) printBusy() {
+	log.Printf("%s Session %s busy, gave up after %v\n", rq.r.RemoteAddr, rq.sess.id, *busyWait)
+	if rq.proxy {
+		rq.w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(rq.w, "<HTML><HEAD>%s<TITLE>WRP Busy</TITLE></HEAD><BODY BGCOLOR=\"%s\">"+
+			"Still working on your previous request, <A HREF=\"%s\">click to refresh</A>."+
+			"</BODY></HTML>", rq.baseTag(), *bgColor, rq.url)
+		return
+	}
+	rq.printUI(uiParams{
+		text: fmt.Sprintf(`<P>Still working on your previous request, <A HREF="%s">click to refresh</A>.</P>`,
+			html.EscapeString(rq.uiQuery(rq.url, "St"))),
+	})
+}
+
+func (ss *sessionStore) setCookie(w http.ResponseWriter, r *http.Request, s *session) {
+	if isProxyRequest(r) || sessionID(r) == s.id {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessCookie, Value: s.id, Path: "/"})
+}
+
+// Close session, caller must hold the lock
+func (ss *sessionStore) close(s *session, why string) {
+	log.Printf("%s Closing session %s: %s", s.addr, s.id, why)
+	s.closeTabs()
+	browsers.release(s.proc)
+	delete(ss.byID, s.id)
+	if ss.byAddr[s.addr] == s {
+		delete(ss.byAddr, s.addr)
+	}
+	ss.expired[s.id] = time.Now()
+	ss.expired[s.addr] = time.Now()
+}
+
+// Session is handling a request or streaming a live view
+func (s *session) active() bool {
+	return len(s.busy) > 0 || s.streams.Load() > 0
+}
+
+// Close the least recently used idle session, active ones are left alone
+// even if that goes over the limit
+func (ss *sessionStore) evictLRU() {
+	var lru *session
+	for _, s := range ss.byID {
+		if s.active() {
+			continue
+		}
+		if lru == nil || s.seen.Before(lru.seen) {
+			lru = s
+		}
+	}
+	if lru != nil {
+		ss.close(lru, "evicted, too many sessions")
+	}
+}
+
+// Close sessions idle for longer than the -idle flag
+func (ss *sessionStore) reaper() {
+	if *sessIdle <= 0 {
+		return
+	}
+	tick := min(*sessIdle, time.Minute)
+	for range time.Tick(tick) {
+		ss.Lock()
+		for _, s := range ss.byID {
+			if time.Since(s.seen) > *sessIdle && !s.active() {
+				ss.close(s, "idle timeout")
+			}
+		}
+		for k, t := range ss.expired {
+			if time.Since(t) > 24*time.Hour {
+				delete(ss.expired, k)
+			}
+		}
+		ss.Unlock()
+	}
+}
+
+// Reopen tabs of sessions hosted by a relaunched browser and navigate
+// them back to where they were
+func (ss *sessionStore) restore(p *browserProc) {
+	ss.Lock()
+	var restore []*session
+	for _, s := range ss.byID {
+		if s.proc == p {
+			restore = append(restore, s)
+		}
+	}
+	ss.Unlock()
+	// sessions busy with a request are retried after it is done
+	for len(restore) > 0 {
+		var busy []*session
+		for _, s := range restore {
+			if !ss.open(s) {
+				continue
+			}
+			if !s.lock() {
+				log.Printf("%s Session %s busy, restoring later", s.addr, s.id)
+				busy = append(busy, s)
+				continue
+			}
+			tabs, _ := s.tabList()
+			for _, t := range tabs {
+				s.reopenTab(t)
+			}
+			s.replay()
+			s.restarted.Store(true)
+			s.unlock()
+		}
+		restore = busy
+	}
+}
+
+// Session has not been closed
+func (ss *sessionStore) open(s *session) bool {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.byID[s.id] == s
+}
+
+func (ss *sessionStore) closeAll() {
+	ss.Lock()
+	defer ss.Unlock()
+	for id, s := range ss.byID {
+		s.closeTabs()
+		delete(ss.byID, id)
+	}
+	ss.byAddr = make(map[string]*session)
+}
@@ -0,0 +1,166 @@
+// WRP per-client settings for proxy mode
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Display settings of a client, used instead of flags in proxy mode
+type clientSettings struct {
+	Width   int64   `json:"width"`
+	Height  int64   `json:"height"`
+	NColors int64   `json:"ncolors"`
+	Zoom    float64 `json:"zoom"`
+	WrpMode string  `json:"mode"`
+	ImgType string  `json:"imgtype"`
+	Palette string  `json:"palette,omitempty"`
+	Dither  string  `json:"dither,omitempty"`
+}
+
+type settingsStore struct {
+	sync.Mutex
+	m map[string]clientSettings
+}
+
+var settings = settingsStore{m: make(map[string]clientSettings)}
+
+// User name from Proxy-Authorization, browsers send it after the -pu challenge
+func proxyUser(r *http.Request) string {
+	a := r.Header.Get("Proxy-Authorization")
+	if b, ok := strings.CutPrefix(a, "Basic "); ok {
+		if d, err := base64.StdEncoding.DecodeString(b); err == nil {
+			u, _, _ := strings.Cut(string(d), ":")
+			return u
+		}
+	}
+	return ""
+}
+
+// Proxy user name if present, otherwise client IP address
+func settingsKey(r *http.Request) string {
+	if u := proxyUser(r); u != "" {
+		return "user:" + u
+	}
+	return clientAddr(r)
+}
+
+func (st *settingsStore) get(key string) (clientSettings, bool) {
+	st.Lock()
+	defer st.Unlock()
+	cs, ok := st.m[key]
+	return cs, ok
+}
+
+func (st *settingsStore) set(key string, cs clientSettings) {
+	st.Lock()
+	defer st.Unlock()
+	st.m[key] = cs
+}
+
+// Override flag defaults with the client's saved settings
+func (rq *wrpReq) applySettings() {
+	cs, ok := settings.get(settingsKey(rq.r))
+	if !ok {
+		return
+	}
+	rq.width = cs.Width
+	rq.height = cs.Height
+	rq.nColors = cs.NColors
+	rq.zoom = cs.Zoom
+	rq.wrpMode = cs.WrpMode
+	rq.imgType = cs.ImgType
+	rq.palette = cs.Palette
+	if cs.Dither != "" {
+		rq.dither = cs.Dither
+	}
+}
+
+func settingsServer(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s Settings Request %s\n", r.RemoteAddr, r.Method)
+	r.ParseForm()
+	key := clientAddr(r)
+	u := r.FormValue("u")
+	if u == "" {
+		u = proxyUser(r)
+	}
+	if u != "" {
+		key = "user:" + u
+	}
+	cs, ok := settings.get(key)
+	if !ok {
+		cs = clientSettings{
+			Width:   defGeom.w,
+			Height:  defGeom.h,
+			NColors: defGeom.c,
+			Zoom:    1.0,
+			WrpMode: *wrpMode,
+			ImgType: *defType,
+			Dither:  *defDither,
+		}
+	}
+	var msg string
+	if r.Method == "POST" {
+		rq := wrpReq{r: r, w: w}
+		rq.parseForm()
+		cs = clientSettings{
+			Width:   rq.width,
+			Height:  rq.height,
+			NColors: rq.nColors,
+			Zoom:    rq.zoom,
+			WrpMode: rq.wrpMode,
+			ImgType: rq.imgType,
+			Palette: rq.palette,
+			Dither:  rq.dither,
+		}
+		settings.set(key, cs)
+		log.Printf("%s Saved settings for %s: %+v\n", r.RemoteAddr, key, cs)
+		msg = "<P><B>Settings saved.</B></P>"
+	}
+	sel := func(name, cur string, opts ...string) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "<SELECT NAME=\"%s\">", name)
+		for _, o := range opts {
+			s := ""
+			if o == cur {
+				s = " SELECTED"
+			}
+			fmt.Fprintf(&b, "<OPTION VALUE=\"%s\"%s>%s</OPTION>", o, s, strings.ToUpper(o))
+		}
+		b.WriteString("</SELECT>")
+		return b.String()
+	}
+	colors := strconv.FormatInt(cs.NColors, 10)
+	if cs.Palette != "" {
+		colors = cs.Palette
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "max-age=0")
+	fmt.Fprintf(w, "<HTML><HEAD><TITLE>WRP Settings</TITLE></HEAD><BODY BGCOLOR=\"%s\">"+
+		"<H2>WRP Proxy Mode Settings</H2>%s"+
+		"<FORM ACTION=\"/settings/\" METHOD=\"POST\"><TABLE>"+
+		"<TR><TD>Proxy User</TD><TD><INPUT TYPE=\"TEXT\" NAME=\"u\" VALUE=\"%s\" SIZE=\"12\"> (empty for %s)</TD></TR>"+
+		"<TR><TD>Width</TD><TD><INPUT TYPE=\"TEXT\" NAME=\"w\" VALUE=\"%d\" SIZE=\"4\"></TD></TR>"+
+		"<TR><TD>Height</TD><TD><INPUT TYPE=\"TEXT\" NAME=\"h\" VALUE=\"%d\" SIZE=\"4\"></TD></TR>"+
+		"<TR><TD>Colors</TD><TD>%s</TD></TR>"+
+		"<TR><TD>Dither</TD><TD>%s</TD></TR>"+
+		"<TR><TD>Zoom</TD><TD>%s</TD></TR>"+
+		"<TR><TD>Mode</TD><TD>%s</TD></TR>"+
+		"<TR><TD>Image Type</TD><TD>%s</TD></TR>"+
+		"</TABLE><INPUT TYPE=\"SUBMIT\" VALUE=\"Save\"></FORM>"+
+		"<P><A HREF=\"/\">Back to WRP</A></P></BODY></HTML>",
+		*bgColor, msg, html.EscapeString(u), html.EscapeString(clientAddr(r)), cs.Width, cs.Height,
+		sel("c", colors, "256", "216", "128", "64", "16", "2",
+			"web216", "win16", "win20", "mac256", "ega", "cga", "gray16", "gray4"),
+		sel("d", cs.Dither, ditherModes...),
+		sel("z", strconv.FormatFloat(cs.Zoom, 'f', 1, 64), "0.7", "0.8", "0.9", "1.0", "1.1", "1.2", "1.3"),
+		sel("m", cs.WrpMode, "ismap", "tiles", "anim", "html"),
+		sel("t", cs.ImgType, "gip", "png", "gif", "jpg"),
+	)
+}
@@ -31,14 +31,8 @@ import (
 	"golang.org/x/net/html"
 )
 
-var imgStor imageStore
-
 const imgZpfx = "/imgz/"
 
-func init() {
-	imgStor.img = make(map[string]imageContainer)
-}
-
 type imageContainer struct {
 	data  []byte
 	url   string
@@ -78,7 +72,7 @@ func (i *imageStore) clear() {
 	i.img = make(map[string]imageContainer)
 }
 
-func fetchImage(id, imgURL, imgType string, maxSize, imgOpt int) (int, int, int, error) {
+func fetchImage(st *imageStore, id, imgURL, imgType string, maxSize, imgOpt int, pal, dither string) (int, int, int, error) {
 	log.Printf("Downloading IMGZ URL=%q for ID=%q", imgURL, id)
 	var in []byte
 	var err error
@@ -118,11 +112,11 @@ func fetchImage(id, imgURL, imgType string, maxSize, imgOpt int) (int, int, int,
 	default:
 		return 0, 0, 0, fmt.Errorf("unsupported image URL scheme: %q", imgURL)
 	}
-	out, w, h, err := smallImg(in, imgType, maxSize, imgOpt)
+	out, w, h, err := smallImg(in, imgType, maxSize, imgOpt, pal, dither)
 	if err != nil {
 		return 0, 0, 0, fmt.Errorf("Error scaling down %q: %v", imgURL, err)
 	}
-	imgStor.add(id, imgURL, out)
+	st.add(id, imgURL, out)
 	return len(out), w, h, nil
 }
 
@@ -157,7 +151,7 @@ func isSVG(src []byte) bool {
 	return strings.HasPrefix(s, "<svg") || strings.HasPrefix(s, "<?xml")
 }
 
-func smallImg(src []byte, imgType string, maxSize, imgOpt int) ([]byte, int, int, error) {
+func smallImg(src []byte, imgType string, maxSize, imgOpt int, pal, dither string) ([]byte, int, int, error) {
 	t := http.DetectContentType(src)
 	var err error
 	var img image.Image
@@ -187,7 +181,7 @@ func smallImg(src []byte, imgType string, maxSize, imgOpt int) ([]byte, int, int
 	case "png":
 		err = png.Encode(&outBuf, img)
 	case "gif":
-		err = gif.Encode(&outBuf, gifPalette(img, int64(imgOpt)), &gif.Options{})
+		err = gif.Encode(&outBuf, gifPalette(img, int64(imgOpt), pal, dither), &gif.Options{})
 	case "jpg":
 		err = jpeg.Encode(&outBuf, img, &jpeg.Options{Quality: imgOpt})
 	}
@@ -315,7 +309,7 @@ func simplifyDOM(doc *goquery.Document, rq *wrpReq) int {
 		wg.Add(1)
 		go func(j imgJob) {
 			defer wg.Done()
-			size, w, h, err := fetchImage(j.seq, j.abs, rq.imgType, int(rq.maxSize), imgOpt)
+			size, w, h, err := fetchImage(&rq.sess.imgs, j.seq, j.abs, rq.imgType, int(rq.maxSize), imgOpt, rq.palette, rq.dither)
 			mu.Lock()
 			defer mu.Unlock()
 			if err != nil {
@@ -335,10 +329,10 @@ func simplifyDOM(doc *goquery.Document, rq *wrpReq) int {
 }
 
 func (rq *wrpReq) captureMarkdown() {
-	imgStor.clear()
+	rq.sess.imgs.clear()
 	log.Printf("Processing simple HTML conversion for %v", rq.url)
 	var outerHTML string
-	err := chromedp.Run(ctx,
+	err := rq.sess.run(
 		waitForRender(),
 		emulation.SetEmulatedMedia().WithMedia("print"),
 		chromedp.Evaluate(`(function(){document.querySelectorAll('*').forEach(function(e){if(getComputedStyle(e).display==='none')e.remove()})})()`, nil),
@@ -347,10 +341,11 @@ func (rq *wrpReq) captureMarkdown() {
 	)
 	if err != nil {
 		log.Printf("Failed to get OuterHTML via CDP: %v", err)
-		http.Error(rq.w, err.Error(), http.StatusInternalServerError)
+		rq.printErr(err)
 		return
 	}
 	log.Printf("Got %v bytes HTML from CDP for %v", len(outerHTML), rq.url)
+	rq.sess.remember(rq)
 
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(outerHTML))
 	if err != nil {
@@ -384,8 +379,14 @@ func (rq *wrpReq) captureMarkdown() {
 
 func imgServerTxt(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s IMGZ Request for %s", r.RemoteAddr, r.URL.Path)
+	s := sessions.find(r)
+	if s == nil {
+		http.Error(w, "no session", http.StatusNotFound)
+		log.Printf("%s IMGZ no session for %s", r.RemoteAddr, r.URL.Path)
+		return
+	}
 	id := strings.Replace(r.URL.Path, imgZpfx, "", 1)
-	img, err := imgStor.get(id)
+	img, err := s.imgs.get(id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Printf("%s IMGZ error for %s: %v", r.RemoteAddr, r.URL.Path, err)
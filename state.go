@@ -0,0 +1,222 @@
+// WRP session persistence across restarts
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const maxHistory = 10
+
+// Contents of the -state file
+type savedState struct {
+	Key      string                    `json:"key"`
+	Sessions []sessionState            `json:"sessions"`
+	Settings map[string]clientSettings `json:"settings"`
+}
+
+// Session state saved in the -state file
+type sessionState struct {
+	ID      string   `json:"id"`
+	Addr    string   `json:"addr"`
+	URL     string   `json:"url"`
+	Width   int64    `json:"width"`
+	Height  int64    `json:"height"`
+	Zoom    float64  `json:"zoom"`
+	NColors int64    `json:"ncolors"`
+	JQual   int64    `json:"jqual"`
+	MaxSize int64    `json:"maxsize"`
+	ImgType string   `json:"imgtype"`
+	WrpMode string   `json:"mode"`
+	Proxy   bool     `json:"proxy"`
+	History []string `json:"history"`
+	Tabs    []string `json:"tabs"`
+	Tab     int      `json:"tab"`
+}
+
+// Remember where the session is and how it is displayed, called after capture
+func (s *session) remember(rq *wrpReq) {
+	var hist []string
+	s.run(chromedp.ActionFunc(func(ctx context.Context) error {
+		cur, entries, err := page.GetNavigationHistory().Do(ctx)
+		if err != nil {
+			return nil
+		}
+		for i := max(0, int(cur)-maxHistory+1); i <= int(cur) && i < len(entries); i++ {
+			hist = append(hist, entries[i].URL)
+		}
+		return nil
+	}))
+	last := *rq
+	last.w, last.r, last.sess = nil, nil, nil
+	last.mouseX, last.mouseY, last.keys, last.buttons = 0, 0, "", ""
+	sessions.Lock()
+	s.last = last
+	s.hist = hist
+	s.tabs[s.cur].url = rq.url
+	sessions.Unlock()
+}
+
+// Navigate fresh tabs back to their pages, the current one through the
+// session history so Bk keeps working
+func (s *session) replay() {
+	sessions.Lock()
+	hist := s.hist
+	url := s.last.url
+	sessions.Unlock()
+	tabs, cur := s.tabList()
+	for i, t := range tabs {
+		if i == cur || t.url == "" {
+			continue
+		}
+		log.Printf("%s Restoring session %s tab %d to %s", s.addr, s.id, i, t.url)
+		if err := s.attempt(t, 1, chromedp.Navigate(t.url)); err != nil {
+			log.Printf("%s Unable to restore session %s tab %d: %v", s.addr, s.id, i, err)
+		}
+	}
+	if len(hist) == 0 && url != "" {
+		hist = []string{url}
+	}
+	for _, u := range hist {
+		log.Printf("%s Restoring session %s to %s", s.addr, s.id, u)
+		if err := s.runOnce(chromedp.Navigate(u)); err != nil {
+			log.Printf("%s Unable to restore session %s: %v", s.addr, s.id, err)
+		}
+	}
+}
+
+func (ss *sessionStore) save() {
+	if *stateFile == "" {
+		return
+	}
+	ss.Lock()
+	var st savedState
+	for _, s := range ss.byID {
+		if s.last.url == "" {
+			continue
+		}
+		var tabs []string
+		for _, t := range s.tabs {
+			tabs = append(tabs, t.url)
+		}
+		st.Sessions = append(st.Sessions, sessionState{
+			ID:      s.id,
+			Addr:    s.addr,
+			URL:     s.last.url,
+			Width:   s.last.width,
+			Height:  s.last.height,
+			Zoom:    s.last.zoom,
+			NColors: s.last.nColors,
+			JQual:   s.last.jQual,
+			MaxSize: s.last.maxSize,
+			ImgType: s.last.imgType,
+			WrpMode: s.last.wrpMode,
+			Proxy:   s.last.proxy,
+			History: s.hist,
+			Tabs:    tabs,
+			Tab:     s.cur,
+		})
+	}
+	ss.Unlock()
+	st.Key = hex.EncodeToString(tokenKey)
+	settings.Lock()
+	st.Settings = settings.m
+	buf, err := json.MarshalIndent(st, "", "  ")
+	settings.Unlock()
+	if err != nil {
+		log.Printf("Unable to encode session state: %v", err)
+		return
+	}
+	tmp := *stateFile + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0600); err != nil {
+		log.Printf("Unable to write session state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, *stateFile); err != nil {
+		log.Printf("Unable to write session state: %v", err)
+	}
+}
+
+func (ss *sessionStore) saver() {
+	if *stateFile == "" {
+		return
+	}
+	for range time.Tick(time.Minute) {
+		ss.save()
+	}
+}
+
+// Recreate sessions from the -state file, pages are reloaded in background.
+// Returns the saved map signing key.
+func (ss *sessionStore) load() string {
+	if *stateFile == "" {
+		return ""
+	}
+	buf, err := os.ReadFile(*stateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read session state: %v", err)
+		}
+		return ""
+	}
+	var st savedState
+	if err := json.Unmarshal(buf, &st); err != nil {
+		log.Printf("Unable to decode session state %s: %v", *stateFile, err)
+		return ""
+	}
+	if st.Settings != nil {
+		settings.Lock()
+		settings.m = st.Settings
+		settings.Unlock()
+	}
+	ss.Lock()
+	defer ss.Unlock()
+	for _, e := range st.Sessions {
+		if *maxSess > 0 && len(ss.byID) >= *maxSess {
+			break
+		}
+		s := newSession(e.ID, e.Addr)
+		s.last = wrpReq{
+			url:     e.URL,
+			width:   e.Width,
+			height:  e.Height,
+			zoom:    e.Zoom,
+			nColors: e.NColors,
+			jQual:   e.JQual,
+			maxSize: e.MaxSize,
+			imgType: e.ImgType,
+			wrpMode: e.WrpMode,
+			proxy:   e.Proxy,
+		}
+		s.hist = e.History
+		for i, u := range e.Tabs {
+			if i > 0 {
+				s.tabs = append(s.tabs, s.openTab())
+			}
+			s.tabs[i].url = u
+		}
+		if e.Tab >= 0 && e.Tab < len(s.tabs) {
+			s.cur = e.Tab
+		}
+		ss.byID[s.id] = s
+		if _, ok := ss.byAddr[s.addr]; !ok {
+			ss.byAddr[s.addr] = s
+		}
+		go func() {
+			if !s.lock() {
+				return
+			}
+			defer s.unlock()
+			s.replay()
+		}()
+	}
+	log.Printf("Restored %d sessions from %s", len(ss.byID), *stateFile)
+	return st.Key
+}
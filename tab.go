@@ -0,0 +1,209 @@
+// WRP multiple tabs per session
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+const maxTabs = 8
+
+// Browser tab within a session
+type tab struct {
+	ctx  context.Context
+	cncl context.CancelFunc
+	url  string
+	// set when the tab or its session is closed, it must not be reopened
+	closed atomic.Bool
+}
+
+func (s *session) openTab() *tab {
+	t := &tab{}
+	s.attachTab(t)
+	return t
+}
+
+// Replace tab target with a fresh one unless the tab was closed meanwhile
+func (s *session) reopenTab(t *tab) {
+	if t.closed.Load() {
+		return
+	}
+	t.cncl()
+	s.attachTab(t)
+	if t.closed.Load() {
+		t.cncl()
+		return
+	}
+	log.Printf("%s Opened new tab for session %s", s.addr, s.id)
+}
+
+func (t *tab) close() {
+	t.closed.Store(true)
+	t.cncl()
+}
+
+// Create the tab target. The first Run attaches it and its event loop lives
+// as long as that Run's context, so it must not carry a per-action timeout.
+func (s *session) attachTab(t *tab) {
+	t.ctx, t.cncl = chromedp.NewContext(browsers.browserCtx(s.proc))
+	setupDownloads(&s.dl, t.ctx)
+	// a hung browser gives up the tab after -rt, t.ctx itself has no deadline
+	tm := time.AfterFunc(*runTimeout, t.cncl)
+	defer tm.Stop()
+	if err := chromedp.Run(t.ctx); err != nil {
+		log.Printf("%s Unable to open tab for session %s: %v", s.addr, s.id, err)
+	}
+}
+
+// Current tab
+func (s *session) tab() *tab {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return s.tabs[s.cur]
+}
+
+func (s *session) tabList() ([]*tab, int) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return append([]*tab(nil), s.tabs...), s.cur
+}
+
+// Open a new tab and make it current, false if there are too many
+func (s *session) newTab() bool {
+	sessions.Lock()
+	n := len(s.tabs)
+	sessions.Unlock()
+	if n >= maxTabs {
+		return false
+	}
+	t := s.openTab()
+	sessions.Lock()
+	s.tabs = append(s.tabs, t)
+	s.cur = len(s.tabs) - 1
+	sessions.Unlock()
+	log.Printf("%s Session %s new tab %d", s.addr, s.id, s.cur)
+	return true
+}
+
+// Close current tab and switch to the previous one, the last tab stays open
+func (s *session) closeTab() {
+	sessions.Lock()
+	defer sessions.Unlock()
+	if len(s.tabs) < 2 {
+		return
+	}
+	s.tabs[s.cur].close()
+	s.tabs = append(s.tabs[:s.cur], s.tabs[s.cur+1:]...)
+	s.cur = max(s.cur-1, 0)
+	log.Printf("%s Session %s closed tab, now on %d", s.addr, s.id, s.cur)
+}
+
+func (s *session) switchTab(n int) bool {
+	sessions.Lock()
+	defer sessions.Unlock()
+	if n < 0 || n >= len(s.tabs) {
+		return false
+	}
+	s.cur = n
+	return true
+}
+
+// Close all tabs, caller must hold the store lock
+func (s *session) closeTabs() {
+	for _, t := range s.tabs {
+		t.close()
+	}
+}
+
+func isTabButton(b string) bool {
+	switch b {
+	case "T+", "T-", "T<", "T>":
+		return true
+	}
+	return false
+}
+
+// Handle tab buttons, returns false if not a tab action
+func (rq *wrpReq) tabAction() bool {
+	s := rq.sess
+	tabs, cur := s.tabList()
+	switch rq.buttons {
+	case "T+":
+		if !s.newTab() {
+			log.Printf("%s Session %s has too many tabs\n", rq.r.RemoteAddr, s.id)
+		}
+		return false // navigate the new tab to the url
+	case "T-":
+		s.closeTab()
+	case "T<":
+		s.switchTab((cur + len(tabs) - 1) % len(tabs))
+	case "T>":
+		s.switchTab((cur + 1) % len(tabs))
+	default:
+		return false
+	}
+	rq.url = s.tab().url
+	return true
+}
+
+// List tabs of the session or switch to one with /tabs/N
+func tabsServer(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s Tabs Request for %s\n", r.RemoteAddr, r.URL.Path)
+	s, _ := sessions.get(w, r)
+	sessions.Lock()
+	rq := s.last
+	sessions.Unlock()
+	rq.r, rq.w, rq.sess = r, w, s
+	if rq.wrpMode == "" {
+		rq.parseForm()
+	}
+	if !s.lock() {
+		rq.printBusy()
+		return
+	}
+	defer s.unlock()
+	if n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/tabs/")); err == nil {
+		if s.switchTab(n) {
+			rq.url = s.tab().url
+			log.Printf("%s Session %s switched to tab %d\n", r.RemoteAddr, s.id, n)
+			if len(rq.url) < 4 {
+				rq.printUI(uiParams{})
+				return
+			}
+			if rq.wrpMode == "html" {
+				rq.captureMarkdown()
+				return
+			}
+			rq.captureScreenshot()
+			return
+		}
+	}
+	tabs, cur := s.tabList()
+	rq.url = tabs[cur].url
+	var b strings.Builder
+	b.WriteString("<P><B>Tabs</B></P>\n<OL>\n")
+	for i, t := range tabs {
+		var title, loc string
+		s.runTab(t, chromedp.Title(&title), chromedp.Location(&loc))
+		if title == "" {
+			title = loc
+		}
+		if i == cur {
+			title = "<B>" + html.EscapeString(title) + "</B>"
+		} else {
+			title = html.EscapeString(title)
+		}
+		fmt.Fprintf(&b, "<LI><A HREF=\"/tabs/%d\">%s</A><BR><FONT SIZE=\"-1\">%s</FONT>\n", i, title, html.EscapeString(loc))
+	}
+	b.WriteString("</OL>\n")
+	rq.printUI(uiParams{text: b.String()})
+}
@@ -0,0 +1,110 @@
+// WRP tiled ISMAP rendering for tall pages
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/tenox7/gip"
+)
+
+const defTileHeight = 600
+
+// Horizontal slice of a full page screenshot
+type tile struct {
+	imgPath string
+	mapPath string
+	offset  int64
+	width   int
+	height  int
+	imgMap  string
+}
+
+func (rq *wrpReq) encodeImage(i image.Image) (bytes.Buffer, error) {
+	var buf bytes.Buffer
+	var err error
+	switch rq.imgType {
+	case "gip":
+		err = gip.Encode(&buf, i, nil)
+	case "png":
+		err = png.Encode(&buf, i)
+	case "gif":
+		err = gif.Encode(&buf, gifPalette(i, rq.nColors, rq.palette, rq.dither), &gif.Options{})
+	case "jpg":
+		err = jpeg.Encode(&buf, i, &jpeg.Options{Quality: int(rq.jQual)})
+	}
+	return buf, err
+}
+
+// Slice full page screenshot into tiles of rq.height pixels, each with its
+// own image and map carrying the tile offset
+func (rq *wrpReq) captureTiles(pngCap []byte, pageH int64, links []pageLink) (*screenshot, error) {
+	i, err := png.Decode(bytes.NewReader(pngCap))
+	if err != nil {
+		log.Printf("%s Failed to decode PNG screenshot: %s\n", rq.r.RemoteAddr, err)
+		return nil, fmt.Errorf("unable to decode page PNG screenshot: %v", err)
+	}
+	th := int(rq.height)
+	if th < 10 {
+		th = defTileHeight
+	}
+	imgExt := rq.imgType
+	if imgExt == "gip" {
+		imgExt = "gif"
+	}
+	st := time.Now()
+	b := i.Bounds()
+	sc := &screenshot{width: b.Dx(), height: b.Dy(), pageH: pageH}
+	var tot int
+	for y := b.Min.Y; y < b.Max.Y; y += th {
+		r := image.Rect(b.Min.X, y, b.Max.X, min(y+th, b.Max.Y))
+		ti := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(ti, ti.Bounds(), i, r.Min, draw.Src)
+		buf, err := rq.encodeImage(ti)
+		if err != nil {
+			log.Printf("%s Failed to encode tile: %s\n", rq.r.RemoteAddr, err)
+			return nil, fmt.Errorf("unable to encode tile: %v", err)
+		}
+		t := *rq
+		t.tileY = int64(y - b.Min.Y)
+		seq := t.token()
+		tl := tile{
+			imgPath: fmt.Sprintf("/img/%s.%s", seq, imgExt),
+			mapPath: fmt.Sprintf("/map/%s.map", seq),
+			offset:  t.tileY,
+			width:   r.Dx(),
+			height:  r.Dy(),
+		}
+		tl.imgMap = rq.imgMap(fmt.Sprintf("wrpmap%d", len(sc.tiles)), links, tl.mapPath, int(t.tileY), tl.height)
+		tot += buf.Len()
+		rq.sess.cache.addImg(tl.imgPath, buf)
+		sc.tiles = append(sc.tiles, tl)
+	}
+	sc.imgPath, sc.mapPath = sc.tiles[0].imgPath, sc.tiles[0].mapPath
+	sc.size = fmt.Sprintf("%.0f KB", float32(tot)/1024.0)
+	log.Printf("%s Encoded %d tiles of %dpx, Size: %s, Res: %dx%d, Time: %vms\n", rq.r.RemoteAddr, len(sc.tiles), th, sc.size, sc.width, sc.height, time.Since(st).Milliseconds())
+	return sc, nil
+}
+
+// HTML table of ISMAP tiles
+func tilesTable(tiles []tile) string {
+	if len(tiles) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<TABLE BORDER=\"0\" CELLPADDING=\"0\" CELLSPACING=\"0\">\n")
+	for i, t := range tiles {
+		fmt.Fprintf(&b, "<TR><TD>%s<A HREF=\"%s\"><IMG SRC=\"%s\" BORDER=\"0\" WIDTH=\"%d\" HEIGHT=\"%d\"%s ISMAP></A></TD></TR>\n",
+			t.imgMap, t.mapPath, t.imgPath, t.width, t.height, useMapAttr(t.imgMap, fmt.Sprintf("wrpmap%d", i)))
+	}
+	b.WriteString("</TABLE>\n")
+	return b.String()
+}
@@ -0,0 +1,168 @@
+// WRP signed map and image tokens
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+const tokenSigLen = 16
+
+var tokenKey []byte
+
+// Page state encoded in map and image paths
+type mapToken struct {
+	URL     string  `json:"u"`
+	Width   int64   `json:"w,omitempty"`
+	Height  int64   `json:"h,omitempty"`
+	Zoom    float64 `json:"z,omitempty"`
+	NColors int64   `json:"c,omitempty"`
+	Palette string  `json:"pl,omitempty"`
+	Dither  string  `json:"d,omitempty"`
+	JQual   int64   `json:"q,omitempty"`
+	ImgType string  `json:"t,omitempty"`
+	ScrollY int64   `json:"y,omitempty"`
+	PageH   int64   `json:"ph,omitempty"`
+	TileY   int64   `json:"o,omitempty"`
+	WrpMode string  `json:"m,omitempty"`
+	Proxy   bool    `json:"p,omitempty"`
+	UseMap  bool    `json:"um,omitempty"`
+	Click   string  `json:"cm,omitempty"`
+	DragX   int64   `json:"dx,omitempty"`
+	DragY   int64   `json:"dy,omitempty"`
+	Wheel   int64   `json:"wd,omitempty"`
+	Frames  int64   `json:"af,omitempty"`
+	Delay   int64   `json:"ai,omitempty"`
+	Refresh int64   `json:"rf,omitempty"`
+	Nonce   string  `json:"n"`
+}
+
+// Set signing key from the -key flag, generate random one if not given
+func initTokenKey(saved string) {
+	var err error
+	switch {
+	case *mapKey != "":
+		tokenKey = []byte(*mapKey)
+		return
+	case saved != "":
+		tokenKey, err = hex.DecodeString(saved)
+		if err == nil {
+			log.Printf("Using map signing key from %s", *stateFile)
+			return
+		}
+	}
+	tokenKey = make([]byte, 32)
+	rand.Read(tokenKey)
+}
+
+func tokenSig(payload string) string {
+	m := hmac.New(sha256.New, tokenKey)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil)[:tokenSigLen])
+}
+
+// Signed token describing the page state of the request. All images of
+// a capture share the nonce.
+func (rq *wrpReq) token() string {
+	if rq.nonce == "" {
+		rq.nonce = shortuuid.New()[:8]
+	}
+	buf, _ := json.Marshal(mapToken{
+		URL:     rq.url,
+		Width:   rq.width,
+		Height:  rq.height,
+		Zoom:    rq.zoom,
+		NColors: rq.nColors,
+		Palette: rq.palette,
+		Dither:  rq.dither,
+		JQual:   rq.jQual,
+		ImgType: rq.imgType,
+		ScrollY: rq.scrollY,
+		PageH:   rq.pageH,
+		TileY:   rq.tileY,
+		WrpMode: rq.wrpMode,
+		Proxy:   rq.proxy,
+		UseMap:  rq.useMap,
+		Click:   rq.clickMode,
+		DragX:   rq.dragX,
+		DragY:   rq.dragY,
+		Wheel:   rq.wheelDelta,
+		Frames:  rq.animFrames,
+		Delay:   rq.animDelay,
+		Refresh: rq.refresh,
+		Nonce:   rq.nonce,
+	})
+	p := base64.RawURLEncoding.EncodeToString(buf)
+	return p + "." + tokenSig(p)
+}
+
+func parseToken(tok string) (wrpReq, error) {
+	p, sig, ok := strings.Cut(tok, ".")
+	if !ok {
+		return wrpReq{}, errors.New("malformed token")
+	}
+	if !hmac.Equal([]byte(sig), []byte(tokenSig(p))) {
+		return wrpReq{}, errors.New("invalid token signature")
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return wrpReq{}, err
+	}
+	var t mapToken
+	if err := json.Unmarshal(buf, &t); err != nil {
+		return wrpReq{}, err
+	}
+	return wrpReq{
+		url:        t.URL,
+		width:      t.Width,
+		height:     t.Height,
+		zoom:       t.Zoom,
+		nColors:    t.NColors,
+		palette:    t.Palette,
+		dither:     t.Dither,
+		jQual:      t.JQual,
+		imgType:    t.ImgType,
+		scrollY:    t.ScrollY,
+		pageH:      t.PageH,
+		tileY:      t.TileY,
+		proxy:      t.Proxy,
+		useMap:     t.UseMap,
+		clickMode:  t.Click,
+		dragX:      t.DragX,
+		dragY:      t.DragY,
+		wheelDelta: t.Wheel,
+		animFrames: t.Frames,
+		animDelay:  t.Delay,
+		refresh:    t.Refresh,
+		wrpMode:    t.WrpMode,
+		maxSize:    *defImgSize,
+	}, nil
+}
+
+// Nonce of a token already checked by parseToken, to recreate the image
+// paths of its capture
+func tokenNonce(tok string) string {
+	p, _, _ := strings.Cut(tok, ".")
+	buf, _ := base64.RawURLEncoding.DecodeString(p)
+	var t mapToken
+	json.Unmarshal(buf, &t)
+	return t.Nonce
+}
+
+// Token from /map/<token>.map or /img/<token>.<ext> path
+func pathToken(path string) string {
+	path = path[strings.LastIndex(path, "/")+1:]
+	if i := strings.LastIndex(path, "."); i > 0 {
+		path = path[:i]
+	}
+	return path
+}
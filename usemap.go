@@ -0,0 +1,90 @@
+// WRP client side USEMAP image maps
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+)
+
+const maxAreas = 500
+
+// Find links and form controls visible in the viewport. Controls larger
+// than a quarter of the viewport are left to the ISMAP, their area would
+// send clicks anywhere on them to the center.
+const linksJS = `(function(){
+var r=[];
+document.querySelectorAll('a[href],area[href],button,input:not([type=hidden]),textarea,select,summary').forEach(function(e){
+	var b=e.getBoundingClientRect();
+	if(b.width<1||b.height<1||b.bottom<0||b.right<0||b.top>innerHeight||b.left>innerWidth)return;
+	var h=(typeof e.href==='string')?e.href:'';
+	if(!h&&b.width*b.height>innerWidth*innerHeight/4)return;
+	var t=(e.innerText||e.title||e.alt||e.value||'').toString().trim().replace(/\s+/g,' ').slice(0,80);
+	r.push({x:b.left,y:b.top,w:b.width,h:b.height,href:h,title:t});
+});
+return r;
+})()`
+
+// Clickable element geometry in CSS pixels
+type pageLink struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	W     float64 `json:"w"`
+	H     float64 `json:"h"`
+	Href  string  `json:"href"`
+	Title string  `json:"title"`
+}
+
+func useMapAttr(imgMap, name string) string {
+	if imgMap == "" {
+		return ""
+	}
+	return fmt.Sprintf(" USEMAP=\"#%s\"", name)
+}
+
+// Build <MAP> for an image showing rows top..top+height of the screenshot.
+// Links navigate directly, other elements click through the ISMAP path.
+// None in other click modes, their ISMAP needs the exact point.
+func (rq *wrpReq) imgMap(name string, links []pageLink, mapPath string, top, height int) string {
+	if !rq.useMap || !rq.plainClicks() || len(links) == 0 {
+		return ""
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].W*links[i].H < links[j].W*links[j].H
+	})
+	var b strings.Builder
+	fmt.Fprintf(&b, "<MAP NAME=\"%s\">\n", name)
+	var n int
+	for _, l := range links {
+		x1 := int(math.Floor(l.X * rq.zoom))
+		y1 := int(math.Floor(l.Y*rq.zoom)) - top
+		x2 := int(math.Ceil((l.X + l.W) * rq.zoom))
+		y2 := int(math.Ceil((l.Y+l.H)*rq.zoom)) - top
+		if y2 <= 0 || y1 >= height {
+			continue
+		}
+		y1, y2 = max(y1, 0), min(y2, height)
+		var href string
+		switch {
+		case strings.HasPrefix(l.Href, "http") && rq.proxy:
+			href = strings.Replace(l.Href, "https://", "http://", 1)
+		case strings.HasPrefix(l.Href, "http"):
+			href = rq.uiQuery(l.Href, "")
+		default:
+			href = fmt.Sprintf("%s?%d,%d", mapPath, (x1+x2)/2, (y1+y2)/2)
+		}
+		alt := l.Title
+		if alt == "" {
+			alt = l.Href
+		}
+		fmt.Fprintf(&b, "<AREA SHAPE=\"RECT\" COORDS=\"%d,%d,%d,%d\" HREF=\"%s\" ALT=\"%s\" TITLE=\"%s\">\n",
+			x1, y1, x2, y2, html.EscapeString(href), html.EscapeString(alt), html.EscapeString(l.Href))
+		if n++; n >= maxAreas {
+			break
+		}
+	}
+	b.WriteString("</MAP>\n")
+	return b.String()
+}
@@ -15,7 +15,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/MaxHalford/halfgone"
 	"github.com/ericpauley/go-quantize/quantize"
 )
 
@@ -45,23 +44,20 @@ func printMyIPs(b string) {
 	log.Printf("Listen address: %v", m)
 }
 
-func gifPalette(i image.Image, n int64) image.Image {
-	switch n {
-	case 2:
-		i = halfgone.FloydSteinbergDitherer{}.Apply(halfgone.ImageToGray(i))
-	default:
-		q := quantize.MedianCutQuantizer{}
-		p := q.Quantize(make([]color.Color, 0, int(n)), i)
-		bounds := i.Bounds()
-		quantized := image.NewPaletted(bounds, p)
-		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-			for x := bounds.Min.X; x < bounds.Max.X; x++ {
-				quantized.Set(x, y, i.At(x, y))
-			}
-		}
-		i = quantized
+// Fixed palette pal if set, otherwise n colors picked from the image
+func quantPalette(i image.Image, n int64, pal string) color.Palette {
+	if p, ok := fixedPalette(pal); ok {
+		return p
+	}
+	if n == 2 {
+		return color.Palette{color.Black, color.White}
 	}
-	return i
+	q := quantize.MedianCutQuantizer{}
+	return q.Quantize(make([]color.Color, 0, int(n)), i)
+}
+
+func gifPalette(i image.Image, n int64, pal, dither string) *image.Paletted {
+	return ditherImage(i, quantPalette(i, n, pal), dither)
 }
 
 func asciify(s []byte) []byte {
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -34,7 +35,7 @@ var (
 	addr        = flag.String("l", ":8080", "Listen address:port, default :8080")
 	headless    = flag.Bool("h", true, "Headless mode / hide browser window (default true)")
 	defType     = flag.String("t", "gip", "Image type: gip|png|gif|jpg")
-	wrpMode     = flag.String("m", "ismap", "WRP Mode: ismap|html")
+	wrpMode     = flag.String("m", "ismap", "WRP Mode: ismap|tiles|anim|html")
 	defImgSize  = flag.Int64("is", 200, "html mode default image size")
 	defJpgQual  = flag.Int64("q", 75, "Jpeg image quality, default 75%") // TODO: this should be form dropdown when jpeg is selected as image type
 	fgeom       = flag.String("g", "1152x600x216", "Geometry: width x height x colors, height can be 0 for unlimited")
@@ -45,15 +46,24 @@ var (
 	searchEng   = flag.String("se", "https://duckduckgo.com/search?q=", "Search engine string")
 	userDataDir = flag.String("profile", "", "Chrome user data dir for persistent cookies/sessions")
 	bgColor     = flag.String("bgcolor", "#F0F0F0", "Background color for WRP UI")
+	defDither   = flag.String("dither", "auto", "GIF dithering: auto|none|fs|atkinson|bayer4|bayer8")
+	defUseMap   = flag.Bool("um", true, "Client side USEMAP image maps for links in ismap mode")
+	sessIdle    = flag.Duration("idle", 30*time.Minute, "Close browser sessions idle for longer than this, 0 to never close")
+	runTimeout  = flag.Duration("rt", 60*time.Second, "Timeout for each attempt of a browser action, quick transient errors of read only actions are retried")
+	busyWait    = flag.Duration("wait", 30*time.Second, "How long a request waits for the previous one in the same session before showing busy page")
+	numBrowsers = flag.Int("nb", 1, "Number of browser processes, new sessions go to the least loaded one")
+	mapKey      = flag.String("key", "", "Secret for signing map and image URLs, random if empty and not in -state file")
+	stateFile   = flag.String("state", "", "File to persist sessions across restarts, empty to disable")
+	liveFPS     = flag.Float64("fps", 2, "Maximum frames per second of the live view")
+	liveMax     = flag.Duration("live", 10*time.Minute, "Maximum duration of a live view stream")
+	maxSess     = flag.Int("maxsess", 20, "Maximum number of browser sessions, least recently used is evicted, 0 for unlimited")
+	askUser     = flag.Bool("pu", false, "Ask proxy mode clients for a user name to keep settings per user instead of per IP")
 )
 
 var (
-	srv         http.Server
-	actx, ctx   context.Context
-	acncl, cncl context.CancelFunc
-	wrpCach wrpCache
-	defGeom     geom
-	htmlTmpl    *template.Template
+	srv      http.Server
+	defGeom  geom
+	htmlTmpl *template.Template
 )
 
 //go:embed *.html
@@ -75,6 +85,8 @@ type uiData struct {
 	URL        string
 	BgColor    string
 	NColors    int64
+	Palette    string
+	Dither     string
 	JQual      int64
 	Width      int64
 	Height     int64
@@ -86,40 +98,87 @@ type uiData struct {
 	ImgHeight  int
 	MaxSize    int64
 	MapURL     string
+	UseMap     bool
+	ClickMode  string
+	WheelDelta int64
+	AnimFrames int64
+	AnimDelay  int64
+	ImgMap     string
+	Forms      string
+	Focus      focusField
+	Tiles      string
 	PageHeight string
+	PageNo     int64
+	Pages      int64
+	StripURL   string
+	StripMap   string
+	LiveURL    string
+	Refresh    int64
+	RefreshURL string
 	TeXT       string
+	Clip       string
+	Tab        int
+	NTabs      int
 }
 
 // Parameters for HTML print function
 type uiParams struct {
 	bgColor    string
 	pageHeight string
+	stripURL   string
+	stripMap   string
+	liveURL    string
 	imgSize    string
 	imgURL     string
 	mapURL     string
 	imgWidth   int
 	imgHeight  int
+	imgMap     string
+	forms      string
+	focus      focusField
+	tiles      []tile
 	text       string
 }
 
 // WRP Request
 type wrpReq struct {
-	url     string
-	width   int64
-	height  int64
-	zoom    float64
-	nColors int64
-	jQual   int64
-	mouseX  int64
-	mouseY  int64
-	keys    string
-	buttons string
-	imgType string
-	wrpMode string
-	maxSize int64
-	proxy   bool
-	w       http.ResponseWriter
-	r       *http.Request
+	url        string
+	width      int64
+	height     int64
+	zoom       float64
+	nColors    int64
+	palette    string
+	dither     string
+	jQual      int64
+	mouseX     int64
+	mouseY     int64
+	scrollY    int64
+	pageH      int64
+	gotoY      int64
+	gotoSet    bool
+	refresh    int64
+	tileY      int64
+	nonce      string
+	useMap     bool
+	clickMode  string
+	dragX      int64
+	dragY      int64
+	wheelDelta int64
+	animFrames int64
+	animDelay  int64
+	keys       string
+	clip       string
+	paste      string
+	focusVal   string
+	focusSet   bool
+	buttons    string
+	imgType    string
+	wrpMode    string
+	maxSize    int64
+	proxy      bool
+	sess       *session
+	w          http.ResponseWriter
+	r          *http.Request
 }
 
 func (rq *wrpReq) baseTag() string {
@@ -160,14 +219,56 @@ func (rq *wrpReq) parseForm() {
 		rq.imgType = *defType
 	}
 	rq.nColors, _ = strconv.ParseInt(rq.r.FormValue("c"), 10, 64)
+	if p, ok := fixedPalette(rq.r.FormValue("c")); ok {
+		rq.palette, rq.nColors = rq.r.FormValue("c"), int64(len(p))
+	}
 	if rq.nColors < 2 || rq.nColors > 256 {
 		rq.nColors = defGeom.c
 	}
+	rq.dither = rq.r.FormValue("d")
+	if !validDither(rq.dither) {
+		rq.dither = *defDither
+	}
 	rq.jQual, _ = strconv.ParseInt(rq.r.FormValue("q"), 10, 64)
 	if rq.jQual < 1 || rq.jQual > 100 {
 		rq.jQual = *defJpgQual
 	}
+	rq.useMap = *defUseMap
+	switch rq.r.FormValue("um") {
+	case "1":
+		rq.useMap = true
+	case "0":
+		rq.useMap = false
+	}
+	rq.clickMode = rq.r.FormValue("cm")
+	switch rq.clickMode {
+	case "click", "double", "right", "hover", "drag", "wheel", "copy":
+	default:
+		rq.clickMode = "click"
+	}
+	if sy, err := strconv.ParseInt(rq.r.FormValue("sy"), 10, 64); err == nil && sy >= 0 {
+		rq.gotoY, rq.gotoSet = sy, true
+	}
+	if pg, err := strconv.ParseInt(rq.r.FormValue("pg"), 10, 64); err == nil && pg > 0 {
+		rq.gotoY, rq.gotoSet = (pg-1)*rq.viewH(), true
+	}
+	rq.animFrames, _ = strconv.ParseInt(rq.r.FormValue("af"), 10, 64)
+	if rq.animFrames < 2 || rq.animFrames > maxAnimFrames {
+		rq.animFrames = defAnimFrames
+	}
+	rq.animDelay, _ = strconv.ParseInt(rq.r.FormValue("ai"), 10, 64)
+	if rq.animDelay < 20 || rq.animDelay > maxAnimDelay {
+		rq.animDelay = defAnimDelay
+	}
+	rq.refresh, _ = strconv.ParseInt(rq.r.FormValue("rf"), 10, 64)
+	rq.refresh = max(rq.refresh, 0)
+	rq.wheelDelta, _ = strconv.ParseInt(rq.r.FormValue("wd"), 10, 64)
+	if rq.wheelDelta == 0 {
+		rq.wheelDelta = defWheelDelta
+	}
 	rq.keys = rq.r.FormValue("k")
+	rq.paste = rq.r.FormValue("pt")
+	rq.focusVal, rq.focusSet = rq.r.FormValue("fv"), rq.r.Form.Has("fv")
 	rq.buttons = rq.r.FormValue("Fn")
 	rq.maxSize, _ = strconv.ParseInt(rq.r.FormValue("s"), 10, 64)
 	if rq.maxSize == 0 {
@@ -176,6 +277,42 @@ func (rq *wrpReq) parseForm() {
 	log.Printf("%s WrpReq from UI Form: %+v\n", rq.r.RemoteAddr, rq)
 }
 
+// UI link to url with the current request settings, inverse of parseForm
+func (rq *wrpReq) uiQuery(u, fn string) string {
+	v := url.Values{}
+	v.Set("url", u)
+	if fn != "" {
+		v.Set("Fn", fn)
+	}
+	v.Set("m", rq.wrpMode)
+	v.Set("w", strconv.FormatInt(rq.width, 10))
+	v.Set("h", strconv.FormatInt(rq.height, 10))
+	v.Set("z", strconv.FormatFloat(rq.zoom, 'f', -1, 64))
+	v.Set("t", rq.imgType)
+	v.Set("c", strconv.FormatInt(rq.nColors, 10))
+	if rq.palette != "" {
+		v.Set("c", rq.palette)
+	}
+	v.Set("d", rq.dither)
+	v.Set("q", strconv.FormatInt(rq.jQual, 10))
+	v.Set("s", strconv.FormatInt(rq.maxSize, 10))
+	v.Set("cm", rq.clickMode)
+	if rq.refresh > 0 {
+		v.Set("rf", strconv.FormatInt(rq.refresh, 10))
+	}
+	v.Set("wd", strconv.FormatInt(rq.wheelDelta, 10))
+	if rq.wrpMode == "anim" {
+		v.Set("af", strconv.FormatInt(rq.animFrames, 10))
+		v.Set("ai", strconv.FormatInt(rq.animDelay, 10))
+	}
+	if rq.useMap {
+		v.Set("um", "1")
+	} else {
+		v.Set("um", "0")
+	}
+	return "/?" + v.Encode()
+}
+
 func (rq *wrpReq) printUI(p uiParams) {
 	rq.w.Header().Set("Cache-Control", "max-age=0")
 	rq.w.Header().Set("Expires", "-1")
@@ -184,6 +321,19 @@ func (rq *wrpReq) printUI(p uiParams) {
 	if p.bgColor == "" {
 		p.bgColor = *bgColor
 	}
+	var tab, nTabs int
+	pageNo, pages := rq.pageOf()
+	var refreshURL string
+	if rq.refresh > 0 && len(rq.url) > 4 {
+		refreshURL = rq.uiQuery(rq.url, "St")
+	}
+	if rq.sess != nil {
+		if rq.sess.restarted.Swap(false) {
+			p.text = sessRestartedText + p.text
+		}
+		tabs, cur := rq.sess.tabList()
+		tab, nTabs = cur+1, len(tabs)
+	}
 	data := uiData{
 		Version:    version,
 		WrpMode:    rq.wrpMode,
@@ -192,6 +342,8 @@ func (rq *wrpReq) printUI(p uiParams) {
 		Width:      rq.width,
 		Height:     rq.height,
 		NColors:    rq.nColors,
+		Palette:    rq.palette,
+		Dither:     rq.dither,
 		JQual:      rq.jQual,
 		Zoom:       rq.zoom,
 		MaxSize:    rq.maxSize,
@@ -201,8 +353,27 @@ func (rq *wrpReq) printUI(p uiParams) {
 		ImgHeight:  p.imgHeight,
 		ImgURL:     p.imgURL,
 		MapURL:     p.mapURL,
+		UseMap:     rq.useMap,
+		ClickMode:  rq.clickMode,
+		WheelDelta: rq.wheelDelta,
+		AnimFrames: rq.animFrames,
+		AnimDelay:  rq.animDelay,
+		ImgMap:     p.imgMap,
+		Forms:      p.forms,
+		Focus:      p.focus,
+		Tiles:      tilesTable(p.tiles),
 		PageHeight: p.pageHeight,
+		PageNo:     pageNo,
+		Pages:      pages,
+		StripURL:   p.stripURL,
+		StripMap:   p.stripMap,
+		LiveURL:    p.liveURL,
+		Refresh:    rq.refresh,
+		RefreshURL: refreshURL,
 		TeXT:       p.text,
+		Clip:       rq.clip,
+		Tab:        tab,
+		NTabs:      nTabs,
 	}
 	err := htmlTmpl.Execute(rq.w, data)
 	if err != nil {
@@ -221,6 +392,11 @@ func proxyServer(w http.ResponseWriter, r *http.Request) {
 		purl = r.URL.String()
 	}
 	log.Printf("%s Proxy Request for %s\n", r.RemoteAddr, purl)
+	if *askUser && proxyUser(r) == "" {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="WRP"`)
+		http.Error(w, "Enter any user name to keep your WRP settings", http.StatusProxyAuthRequired)
+		return
+	}
 	rq := wrpReq{
 		r:       r,
 		w:       w,
@@ -228,21 +404,35 @@ func proxyServer(w http.ResponseWriter, r *http.Request) {
 		width:   defGeom.w,
 		height:  defGeom.h,
 		nColors: defGeom.c,
+		dither:  *defDither,
 		zoom:    1.0,
 		imgType: *defType,
 		wrpMode: *wrpMode,
 		maxSize: *defImgSize,
 		jQual:   *defJpgQual,
+		useMap:  *defUseMap,
 		proxy:   true,
 	}
+	rq.applySettings()
+	rq.sess, _ = sessions.get(w, r)
+	if !rq.sess.lock() {
+		rq.printBusy()
+		return
+	}
+	defer rq.sess.unlock()
 	var currentURL string
-	chromedp.Run(ctx, chromedp.Location(&currentURL))
+	rq.sess.run(chromedp.Location(&currentURL))
 	currentURL = strings.Replace(currentURL, "https://", "http://", 1)
 	if currentURL != strings.Replace(rq.url, "https://", "http://", 1) {
-		if dl := rq.navigate(); dl != nil {
+		dl, err := rq.navigate()
+		if dl != nil {
 			writeDownload(w, dl)
 			return
 		}
+		if err != nil {
+			rq.printErr(err)
+			return
+		}
 	}
 	rq.url = strings.Replace(rq.url, "https://", "http://", 1)
 	if r.Method == "CONNECT" {
@@ -260,6 +450,24 @@ func isProxyRequest(r *http.Request) bool {
 	return r.URL.IsAbs() || r.Method == "CONNECT"
 }
 
+// Proxy request addressed to WRP itself, eg. with a manual proxy setup
+func isSelfRequest(r *http.Request) bool {
+	a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && r.URL.Host == a.String()
+}
+
+// Serve a WRP endpoint, proxy requests for the same path on other sites
+// go to the proxy
+func wrpOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isProxyRequest(r) && !isSelfRequest(r) {
+			pageServer(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
 func pageServer(w http.ResponseWriter, r *http.Request) {
 	log.Printf("%s %s %s [%+v]\n", r.RemoteAddr, r.Method, r.URL, r.Host)
 	if isProxyRequest(r) {
@@ -271,15 +479,31 @@ func pageServer(w http.ResponseWriter, r *http.Request) {
 		r: r,
 		w: w,
 	}
+	var expired bool
+	rq.sess, expired = sessions.get(w, r)
 	rq.parseForm()
+	if expired {
+		rq.printUI(uiParams{text: sessExpiredText})
+		return
+	}
 	if len(rq.url) < 4 {
 		rq.printUI(uiParams{})
 		return
 	}
-	if dl := rq.navigate(); dl != nil {
+	if !rq.sess.lock() {
+		rq.printBusy()
+		return
+	}
+	defer rq.sess.unlock()
+	dl, err := rq.navigate()
+	if dl != nil {
 		http.Redirect(w, r, cacheDownload(dl), http.StatusFound)
 		return
 	}
+	if err != nil {
+		rq.printErr(err)
+		return
+	}
 	if rq.wrpMode == "html" {
 		rq.captureMarkdown()
 		return
@@ -309,8 +533,9 @@ func haltServer(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Shutting down WRP...\n")
 	w.(http.Flusher).Flush()
 	time.Sleep(time.Second * 2)
-	cncl()
-	acncl()
+	sessions.save()
+	sessions.closeAll()
+	browsers.closeAll()
 	srv.Shutdown(context.Background())
 	os.Exit(1)
 }
@@ -361,11 +586,17 @@ func main() {
 	if err != nil || n != 3 {
 		log.Fatalf("Unable to parse -g geometry flag / %s", err)
 	}
+	if *liveFPS <= 0 {
+		log.Fatalf("Invalid -fps %v, must be greater than 0", *liveFPS)
+	}
 
-	cncl, acncl = chromedpStart()
-	defer cncl()
-	defer acncl()
-	setupDownloads()
+	chromedpStart()
+	defer browsers.closeAll()
+	initDownloads()
+	initTokenKey(sessions.load())
+	go sessions.saver()
+	go sessions.reaper()
+	go browsers.monitor()
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -373,19 +604,22 @@ func main() {
 		<-c
 		log.Printf("Interrupt - shutting down.")
 		os.RemoveAll(dlDir)
-		cncl()
-		acncl()
+		sessions.save()
+		sessions.closeAll()
+		browsers.closeAll()
 		srv.Shutdown(context.Background())
 		os.Exit(1)
 	}()
 
-	wrpCach.imgs = make(map[string]cachedImg)
-	wrpCach.maps = make(map[string]cachedMap)
-
 	http.HandleFunc("/", pageServer)
 	http.HandleFunc("/map/", mapServer)
 	http.HandleFunc("/img/", imgServerMap)
 	http.HandleFunc(imgZpfx, imgServerTxt)
+	http.HandleFunc("/form/", formServer)
+	http.HandleFunc("/strip/", wrpOnly(stripServer))
+	http.HandleFunc("/live/", wrpOnly(liveServer))
+	http.HandleFunc("/tabs/", wrpOnly(tabsServer))
+	http.HandleFunc("/settings/", wrpOnly(settingsServer))
 	http.HandleFunc("/proxy.pac", pacServer)
 	http.HandleFunc("/shutdown/", haltServer)
 	http.HandleFunc("/dl/", dlServer)